@@ -14,15 +14,28 @@ package inverted
 import (
 	"bytes"
 	"encoding/binary"
-	"strings"
-	"unicode"
+	"fmt"
 
 	"github.com/semi-technologies/weaviate/entities/models"
 )
 
 type Countable struct {
-	Data          []byte
+	Data []byte
+	// TermFrequency is the term's relative frequency within the document
+	// (TermCount / the property's total token count), kept for callers that
+	// only need a quick relevance signal without going through a Scorer.
 	TermFrequency float32
+	// TermCount is the raw number of times this term occurred in the
+	// document. Query-time scoring (see Scorer) works from this and
+	// Property.Length rather than TermFrequency, since a stored ratio can't
+	// be corrected for later as the collection's average document length
+	// shifts.
+	TermCount uint32
+	// Positions holds the (zero-based, pre-deduplication) token positions
+	// this term occurred at within the document. It is only populated for
+	// analyzed text/string properties, and is what PhraseSearch uses to
+	// verify positional adjacency between terms.
+	Positions []uint32
 }
 
 type Property struct {
@@ -31,65 +44,82 @@ type Property struct {
 	HasFrequency bool
 }
 
+// Length returns the property's total token count in this document (i.e.
+// document length for BM25 purposes): the sum of each item's TermCount,
+// which together add back up to the token count before deduplication.
+func (p *Property) Length() int {
+	var total int
+	for _, item := range p.Items {
+		total += int(item.TermCount)
+	}
+	return total
+}
+
 type Analyzer struct {
 }
 
-// Text removes non alpha-numeric and splits into words, then aggregates
-// duplicates
+// Text tokenizes and analyzes in using the default text pipeline
+// (lowercasing only, no stemming or stop words), then aggregates
+// duplicates. This preserves the historic default behavior of this
+// method; to run a named pipeline instead (e.g. "english", "russian",
+// "keyword"), call TextWithAnalyzer.
 func (a *Analyzer) Text(in string) []Countable {
-	parts := strings.FieldsFunc(in, func(c rune) bool {
-		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
-	})
-
-	terms := map[string]uint32{}
-	total := 0
-	for _, word := range parts {
-		word = strings.ToLower(word)
-		count, ok := terms[word]
-		if !ok {
-			terms[word] = 0
-		}
-		terms[word] = count + 1
-		total++
-	}
+	out, _ := a.TextWithAnalyzer(DefaultTextAnalyzer, in)
+	return out
+}
 
-	out := make([]Countable, len(terms))
-	i := 0
-	for term, count := range terms {
-		out[i] = Countable{
-			Data:          []byte(term),
-			TermFrequency: float32(count) / float32(total),
-		}
-		i++
+// TextWithAnalyzer runs in through the pipeline registered under name
+// (see RegisterAnalyzer), aggregating duplicate terms and computing
+// per-document term frequency the same way Text always has. This is the
+// entry point a class-property resolves to once it configures a named
+// analyzer via the schema.
+func (a *Analyzer) TextWithAnalyzer(name, in string) ([]Countable, error) {
+	pipeline, ok := GetAnalyzer(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown analyzer %q", name)
 	}
 
-	return out
+	return aggregateTerms(pipeline.Run(in)), nil
 }
 
 // String splits only on spaces and does not lowercase, then aggregates
-// duplicates
+// duplicates. This preserves the historic default behavior of this
+// method; to run a named pipeline instead, call TextWithAnalyzer with
+// DefaultStringAnalyzer or another registered name.
 func (a *Analyzer) String(in string) []Countable {
-	parts := strings.FieldsFunc(in, func(c rune) bool {
-		return unicode.IsSpace(c)
-	})
+	pipeline, ok := GetAnalyzer(DefaultStringAnalyzer)
+	if !ok {
+		// the default pipelines are always registered in this package's
+		// init(), and the registry has no way to remove a name (re-registering
+		// replaces it), so this is not reached in practice
+		return nil
+	}
 
+	return aggregateTerms(pipeline.Run(in))
+}
+
+// aggregateTerms deduplicates a token stream, computes each term's
+// frequency within the document (count / total token count), and records
+// the position (assigned incrementally, before deduplication) each
+// occurrence was found at, for later use by phrase queries.
+func aggregateTerms(tokens []Token) []Countable {
 	terms := map[string]uint32{}
-	total := 0
-	for _, word := range parts {
-		count, ok := terms[word]
-		if !ok {
-			terms[word] = 0
-		}
-		terms[word] = count + 1
-		total++
+	positions := map[string][]uint32{}
+	for position, tok := range tokens {
+		term := string(tok.Term)
+		terms[term]++
+		positions[term] = append(positions[term], uint32(position))
 	}
 
+	total := len(tokens)
 	out := make([]Countable, len(terms))
 	i := 0
 	for term, count := range terms {
 		out[i] = Countable{
 			Data:          []byte(term),
 			TermFrequency: float32(count) / float32(total),
+			TermCount:     count,
+			Positions:     positions[term],
 		}
 		i++
 	}
@@ -0,0 +1,53 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+// BatchBuilder accumulates the analyzed properties of every document in an
+// import batch, so the whole batch can be committed to the index with a
+// single call rather than once per document. In the segment-based
+// inverted index (see inverted/segment), that turns "N objects in one
+// import batch" into "one new segment" holding all of their postings,
+// instead of N tiny segments that would all have to be merged back
+// together later -- the same "accumulate, then flush once" idea that used
+// to apply to rewriting a shared bolt row, just achieved by a different
+// mechanism now that postings live in segments rather than bolt rows.
+type BatchBuilder struct {
+	props  [][]Property
+	docIDs []uint32
+}
+
+// NewBatchBuilder creates an empty builder.
+func NewBatchBuilder() *BatchBuilder {
+	return &BatchBuilder{}
+}
+
+// Add accumulates one document's already-analyzed properties into the
+// batch. It does not touch the index; call Flush once the whole batch has
+// been added.
+func (b *BatchBuilder) Add(docID uint32, props []Property) {
+	b.props = append(b.props, props)
+	b.docIDs = append(b.docIDs, docID)
+}
+
+// Len returns how many documents have been accumulated so far.
+func (b *BatchBuilder) Len() int {
+	return len(b.docIDs)
+}
+
+// Flush returns the accumulated batch in the index-aligned form
+// segment.Store.NewSegment expects, and resets the builder so it can be
+// reused for the next batch.
+func (b *BatchBuilder) Flush() (props [][]Property, docIDs []uint32) {
+	props, docIDs = b.props, b.docIDs
+	b.props, b.docIDs = nil, nil
+	return props, docIDs
+}
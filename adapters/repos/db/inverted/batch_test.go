@@ -0,0 +1,46 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchBuilderAccumulatesInAddOrder(t *testing.T) {
+	b := NewBatchBuilder()
+	assert.Equal(t, 0, b.Len())
+
+	propsA := []Property{{Name: "title"}}
+	propsB := []Property{{Name: "title"}, {Name: "body"}}
+
+	b.Add(10, propsA)
+	b.Add(20, propsB)
+	assert.Equal(t, 2, b.Len())
+
+	props, docIDs := b.Flush()
+	assert.Equal(t, []uint32{10, 20}, docIDs)
+	assert.Equal(t, [][]Property{propsA, propsB}, props)
+}
+
+func TestBatchBuilderFlushResetsTheBuilder(t *testing.T) {
+	b := NewBatchBuilder()
+	b.Add(1, []Property{{Name: "title"}})
+	b.Flush()
+
+	assert.Equal(t, 0, b.Len())
+
+	props, docIDs := b.Flush()
+	assert.Empty(t, props)
+	assert.Empty(t, docIDs)
+}
@@ -0,0 +1,446 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+// Package postings contains the roaring-bitmap-backed representation of a
+// single inverted index row (i.e. "posting list"). A row maps a term to the
+// set of docIDs it occurs in; for properties where Property.HasFrequency is
+// set, it additionally carries a (docID -> term frequency) side table, since
+// roaring.Bitmap itself can only store the docID set.
+package postings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/pkg/errors"
+)
+
+// Row format versions. version1 rows (roaring bitmap + frequencies) predate
+// positional postings; version2 additionally carries a per-docID token
+// position list, needed to answer phrase queries. Both are distinguished
+// from the pre-roaring flat-array format by IsLegacyRow.
+const (
+	version1      byte = 1
+	version2      byte = 2
+	currentVersion     = version2
+)
+
+// List is the in-memory representation of one posting row: the bitmap of
+// matching docIDs, an optional per-docID term frequency, and an optional
+// per-docID list of token positions (populated only for properties with
+// HasFrequency set, since positions only make sense where the analyzer
+// assigns them).
+type List struct {
+	docIDs      *roaring.Bitmap
+	frequencies map[uint32]float32
+	positions   map[uint32][]uint32
+}
+
+// NewList creates an empty posting list.
+func NewList() *List {
+	return &List{docIDs: roaring.NewBitmap()}
+}
+
+// Writer mutates a List. It is intentionally not safe for concurrent use;
+// callers (e.g. Shard) are expected to serialize writes per row the same
+// way they previously serialized bolt.Bucket.Put calls.
+type Writer struct {
+	list *List
+}
+
+// NewWriter wraps list for mutation. If list is nil, a new empty List is
+// created.
+func NewWriter(list *List) *Writer {
+	if list == nil {
+		list = NewList()
+	}
+	return &Writer{list: list}
+}
+
+// Add inserts docID into the posting list. It is a no-op if docID is
+// already present.
+func (w *Writer) Add(docID uint32) {
+	w.list.docIDs.Add(docID)
+}
+
+// AddWithFrequency inserts docID, records freq (the term's raw count in
+// the document, see inverted.Countable.TermCount -- not a document-scoped
+// ratio, so a query-time Scorer can recompute relevance as the
+// collection's average document length shifts), and (if non-empty) the
+// token positions the term occurred at within the document, needed to
+// later answer phrase queries. Use this instead of Add for properties
+// where HasFrequency is true.
+func (w *Writer) AddWithFrequency(docID uint32, freq float32, positions []uint32) {
+	w.list.docIDs.Add(docID)
+	if w.list.frequencies == nil {
+		w.list.frequencies = make(map[uint32]float32)
+	}
+	w.list.frequencies[docID] = freq
+
+	if len(positions) == 0 {
+		return
+	}
+	if w.list.positions == nil {
+		w.list.positions = make(map[uint32][]uint32)
+	}
+	w.list.positions[docID] = positions
+}
+
+// Remove deletes docID from the posting list, including its frequency and
+// position entries, if any.
+func (w *Writer) Remove(docID uint32) {
+	w.list.docIDs.Remove(docID)
+	if w.list.frequencies != nil {
+		delete(w.list.frequencies, docID)
+	}
+	if w.list.positions != nil {
+		delete(w.list.positions, docID)
+	}
+}
+
+// List returns the List being written to, e.g. to hand it to a Reader or to
+// serialize it.
+func (w *Writer) List() *List {
+	return w.list
+}
+
+// Reader provides read-only, set-oriented access to a List.
+type Reader struct {
+	list *List
+}
+
+// NewReader wraps list for reading. If list is nil, an empty List is used,
+// so callers don't need to special-case a term that has never been
+// written.
+func NewReader(list *List) *Reader {
+	if list == nil {
+		list = NewList()
+	}
+	return &Reader{list: list}
+}
+
+// Contains returns whether docID is present in the posting list.
+func (r *Reader) Contains(docID uint32) bool {
+	return r.list.docIDs.Contains(docID)
+}
+
+// Frequency returns the raw term count recorded for docID, if any (see
+// AddWithFrequency). ok is false for postings without frequencies, or if
+// docID is not present.
+func (r *Reader) Frequency(docID uint32) (freq float32, ok bool) {
+	freq, ok = r.list.frequencies[docID]
+	return
+}
+
+// Positions returns the token positions recorded for docID, if any. ok is
+// false for postings without positions (e.g. rows written before phrase
+// query support, or properties without HasFrequency), or if docID is not
+// present.
+func (r *Reader) Positions(docID uint32) (positions []uint32, ok bool) {
+	positions, ok = r.list.positions[docID]
+	return
+}
+
+// Cardinality returns the number of docIDs in the posting list.
+func (r *Reader) Cardinality() uint64 {
+	return r.list.docIDs.GetCardinality()
+}
+
+// Bitmap returns a clone of the underlying docID bitmap, safe for the
+// caller to mutate or hand to roaring's set operations.
+func (r *Reader) Bitmap() *roaring.Bitmap {
+	return r.list.docIDs.Clone()
+}
+
+// And intersects this posting list with other, e.g. to evaluate a
+// multi-term AND query.
+func (r *Reader) And(other *Reader) *roaring.Bitmap {
+	return roaring.And(r.list.docIDs, other.list.docIDs)
+}
+
+// Or unions this posting list with other, e.g. to evaluate a multi-term OR
+// query.
+func (r *Reader) Or(other *Reader) *roaring.Bitmap {
+	return roaring.Or(r.list.docIDs, other.list.docIDs)
+}
+
+// AndNot returns the docIDs present in this posting list but absent from
+// other, e.g. to evaluate a NOT clause.
+func (r *Reader) AndNot(other *Reader) *roaring.Bitmap {
+	return roaring.AndNot(r.list.docIDs, other.list.docIDs)
+}
+
+// Iterator returns an ascending iterator over the docIDs in the posting
+// list, for use by query-time scorers that need to walk matches in order.
+func (r *Reader) Iterator() roaring.IntPeekable {
+	return r.list.docIDs.Iterator()
+}
+
+// Marshal serializes list into the current on-disk row format (version2):
+// a one-byte version, the roaring bitmap in its native portable format,
+// a varint count of docIDs with a frequency (0 if there are none), then
+// one tuple per such docID: a varint docID-delta, the frequency's float32
+// bits as 4 little-endian bytes, a varint position count, and varint
+// position deltas. Position deltas restart from zero for every docID.
+func Marshal(list *List) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(currentVersion)
+
+	if _, err := list.docIDs.WriteTo(buf); err != nil {
+		return nil, errors.Wrap(err, "write roaring bitmap")
+	}
+
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(varintBuf, uint64(len(list.frequencies)))
+	buf.Write(varintBuf[:n])
+
+	var lastDocID uint32
+	for _, docID := range list.docIDs.ToArray() {
+		freq, ok := list.frequencies[docID]
+		if !ok {
+			continue
+		}
+
+		n = binary.PutUvarint(varintBuf, uint64(docID-lastDocID))
+		buf.Write(varintBuf[:n])
+		lastDocID = docID
+
+		bits := math.Float32bits(freq)
+		binary.Write(buf, binary.LittleEndian, bits)
+
+		positions := list.positions[docID]
+		n = binary.PutUvarint(varintBuf, uint64(len(positions)))
+		buf.Write(varintBuf[:n])
+
+		var lastPos uint32
+		for _, pos := range positions {
+			n = binary.PutUvarint(varintBuf, uint64(pos-lastPos))
+			buf.Write(varintBuf[:n])
+			lastPos = pos
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unmarshal parses a row previously produced by Marshal, in either the
+// current version2 format or the version1 format that predates positional
+// postings (positions come back empty for those rows). Rows produced by
+// the pre-roaring flat-array format are not accepted here; call
+// UnmarshalLegacy (or run the on-open migration) for those.
+func Unmarshal(data []byte) (*List, error) {
+	if len(data) == 0 {
+		return NewList(), nil
+	}
+
+	switch data[0] {
+	case version1:
+		return unmarshalV1(data[1:])
+	case version2:
+		return unmarshalV2(data[1:])
+	default:
+		return nil, errors.Errorf("unsupported postings row version %d", data[0])
+	}
+}
+
+func unmarshalV1(data []byte) (*List, error) {
+	r := bytes.NewReader(data)
+	bm := roaring.NewBitmap()
+	if _, err := bm.ReadFrom(r); err != nil {
+		return nil, errors.Wrap(err, "read roaring bitmap")
+	}
+
+	list := &List{docIDs: bm}
+
+	freqCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			// no frequency section, e.g. a property without HasFrequency
+			return list, nil
+		}
+		return nil, errors.Wrap(err, "read frequency count")
+	}
+
+	if freqCount > 0 {
+		list.frequencies = make(map[uint32]float32, freqCount)
+		var docID uint32
+		for i := uint64(0); i < freqCount; i++ {
+			delta, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errors.Wrap(err, "read docID delta")
+			}
+			docID += uint32(delta)
+
+			var bits uint32
+			if err := binary.Read(r, binary.LittleEndian, &bits); err != nil {
+				return nil, errors.Wrap(err, "read frequency bits")
+			}
+
+			list.frequencies[docID] = math.Float32frombits(bits)
+		}
+	}
+
+	return list, nil
+}
+
+func unmarshalV2(data []byte) (*List, error) {
+	r := bytes.NewReader(data)
+	bm := roaring.NewBitmap()
+	if _, err := bm.ReadFrom(r); err != nil {
+		return nil, errors.Wrap(err, "read roaring bitmap")
+	}
+
+	list := &List{docIDs: bm}
+
+	freqCount, err := binary.ReadUvarint(r)
+	if err != nil {
+		if err == io.EOF {
+			// no frequency section, e.g. a property without HasFrequency
+			return list, nil
+		}
+		return nil, errors.Wrap(err, "read frequency count")
+	}
+
+	if freqCount == 0 {
+		return list, nil
+	}
+
+	list.frequencies = make(map[uint32]float32, freqCount)
+	list.positions = make(map[uint32][]uint32, freqCount)
+	var docID uint32
+	for i := uint64(0); i < freqCount; i++ {
+		delta, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "read docID delta")
+		}
+		docID += uint32(delta)
+
+		var bits uint32
+		if err := binary.Read(r, binary.LittleEndian, &bits); err != nil {
+			return nil, errors.Wrap(err, "read frequency bits")
+		}
+		list.frequencies[docID] = math.Float32frombits(bits)
+
+		posCount, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, errors.Wrap(err, "read position count")
+		}
+
+		if posCount == 0 {
+			continue
+		}
+
+		positions := make([]uint32, posCount)
+		var pos uint32
+		for j := uint64(0); j < posCount; j++ {
+			delta, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, errors.Wrap(err, "read position delta")
+			}
+			pos += uint32(delta)
+			positions[j] = pos
+		}
+		list.positions[docID] = positions
+	}
+
+	return list, nil
+}
+
+// IsLegacyRow returns true if data looks like a row written by the
+// pre-roaring flat little-endian array format (CRC32 prefix + doc count),
+// rather than a row already migrated to this package's version1 or
+// version2 format.
+//
+// A version byte alone isn't a safe discriminator: a legacy row's leading
+// CRC32 checksum is effectively random, so its low byte equals version1 or
+// version2 for ~0.8% of legacy rows. Those are additionally checked against
+// the legacy layout -- a 4-byte checksum, a 4-byte record count, then that
+// many 4- or 8-byte records -- before being trusted as a current-format row.
+func IsLegacyRow(data []byte) bool {
+	if len(data) == 0 {
+		return true
+	}
+	if data[0] != version1 && data[0] != version2 {
+		return true
+	}
+	return looksLikeLegacyLayout(data)
+}
+
+// looksLikeLegacyLayout reports whether data's length is consistent with
+// the legacy flat-array format's declared record count, for either the
+// with- or without-frequency record size.
+func looksLikeLegacyLayout(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+
+	count := uint64(binary.LittleEndian.Uint32(data[4:8]))
+	remaining := uint64(len(data) - 8)
+	return remaining == count*4 || remaining == count*8
+}
+
+// legacyOccurrenceCount is recorded as every migrated posting's raw term
+// count (see AddWithFrequency). The legacy row being migrated stored
+// Countable.TermFrequency, a document-scoped ratio count/total in (0,1],
+// not a raw count, and the per-document total needed to invert that ratio
+// back into one isn't recoverable at migration time (see
+// Shard.rebuildStats). Using the ratio as-is would silently truncate to 0
+// at score time (uint32(freq) for any freq < 1.0), ranking every migrated
+// document as if the term never occurred; recording 1 instead keeps the
+// term contributing to TF-IDF, it just can't reflect how many times it
+// repeated within the document.
+const legacyOccurrenceCount = 1
+
+// UnmarshalLegacy parses a row written by the pre-roaring flat little-endian
+// array format: a 4-byte CRC32 checksum, a 4-byte doc count, then either
+// 4-byte docIDs (hasFrequency == false) or 4-byte docID/4-byte float32
+// frequency pairs (hasFrequency == true). The checksum is not
+// re-verified here; callers that care about corruption should have already
+// checked it before migrating. See legacyOccurrenceCount for why the
+// on-disk frequency value itself is discarded rather than copied in.
+func UnmarshalLegacy(data []byte, hasFrequency bool) (*List, error) {
+	list := NewList()
+	if len(data) <= 8 {
+		return list, nil
+	}
+
+	// skip the 4-byte checksum and 4-byte doc count, both already reflected
+	// in len(data) and the loop below
+	r := bytes.NewReader(data[8:])
+	for {
+		var docID uint32
+		if err := binary.Read(r, binary.LittleEndian, &docID); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "read legacy doc id")
+		}
+
+		if !hasFrequency {
+			list.docIDs.Add(docID)
+			continue
+		}
+
+		// read and discard the stored ratio; see legacyOccurrenceCount
+		var ratio float32
+		if err := binary.Read(r, binary.LittleEndian, &ratio); err != nil {
+			return nil, errors.Wrap(err, "read legacy frequency")
+		}
+
+		w := NewWriter(list)
+		w.AddWithFrequency(docID, legacyOccurrenceCount, nil)
+	}
+
+	return list, nil
+}
@@ -0,0 +1,183 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package postings
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarshalUnmarshalRoundTrip(t *testing.T) {
+	list := NewList()
+	w := NewWriter(list)
+	w.Add(1)
+	w.AddWithFrequency(2, 3, []uint32{0, 4})
+	w.AddWithFrequency(5, 1, nil)
+
+	data, err := Marshal(list)
+	require.Nil(t, err)
+
+	got, err := Unmarshal(data)
+	require.Nil(t, err)
+
+	r := NewReader(got)
+	assert.True(t, r.Contains(1))
+	assert.True(t, r.Contains(2))
+	assert.True(t, r.Contains(5))
+	assert.False(t, r.Contains(3))
+
+	freq, ok := r.Frequency(2)
+	assert.True(t, ok)
+	assert.Equal(t, float32(3), freq)
+
+	positions, ok := r.Positions(2)
+	assert.True(t, ok)
+	assert.Equal(t, []uint32{0, 4}, positions)
+
+	freq, ok = r.Frequency(5)
+	assert.True(t, ok)
+	assert.Equal(t, float32(1), freq)
+	_, ok = r.Positions(5)
+	assert.False(t, ok)
+
+	_, ok = r.Frequency(1)
+	assert.False(t, ok)
+}
+
+func TestUnmarshalV1HasNoPositions(t *testing.T) {
+	// a version1 row: version byte, roaring bitmap, varint frequency count,
+	// then (docID-delta, frequency-bits) pairs with no position section at
+	// all -- the layout that predates phrase query support.
+	buf := bytes.NewBuffer(nil)
+	buf.WriteByte(version1)
+
+	bm := roaring.NewBitmap()
+	bm.Add(7)
+	_, err := bm.WriteTo(buf)
+	require.Nil(t, err)
+
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(varintBuf, 1)
+	buf.Write(varintBuf[:n])
+
+	n = binary.PutUvarint(varintBuf, 7)
+	buf.Write(varintBuf[:n])
+
+	bits := make([]byte, 4)
+	binary.LittleEndian.PutUint32(bits, math.Float32bits(2))
+	buf.Write(bits)
+
+	got, err := Unmarshal(buf.Bytes())
+	require.Nil(t, err)
+
+	r := NewReader(got)
+	freq, ok := r.Frequency(7)
+	assert.True(t, ok)
+	assert.Equal(t, float32(2), freq)
+	_, ok = r.Positions(7)
+	assert.False(t, ok)
+}
+
+func TestIsLegacyRow(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"empty is legacy", nil, true},
+		{"unknown version byte is legacy", []byte{9, 0, 0, 0, 0}, true},
+		{
+			"version byte with legacy-shaped layout is legacy despite collision",
+			legacyRow(t, version1, []uint32{1, 2}, nil),
+			true,
+		},
+		{
+			"version byte with non-legacy-shaped layout is current format",
+			append([]byte{version1}, 0xAB, 0xCD, 0xEF),
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsLegacyRow(tt.data))
+		})
+	}
+}
+
+func TestUnmarshalLegacyWithoutFrequency(t *testing.T) {
+	data := legacyRow(t, 0, []uint32{10, 20, 30}, nil)
+
+	list, err := UnmarshalLegacy(data, false)
+	require.Nil(t, err)
+
+	r := NewReader(list)
+	assert.True(t, r.Contains(10))
+	assert.True(t, r.Contains(20))
+	assert.True(t, r.Contains(30))
+	assert.Equal(t, uint64(3), r.Cardinality())
+}
+
+func TestUnmarshalLegacyWithFrequencyRecordsOccurrenceOnce(t *testing.T) {
+	// legacy rows stored a document-scoped ratio (count/total) rather than a
+	// raw count; any value in (0, 1] should come back as a raw TermCount of
+	// 1, not truncate to 0 (see legacyOccurrenceCount).
+	data := legacyRow(t, 0, []uint32{10, 20}, []float32{0.1, 0.9})
+
+	list, err := UnmarshalLegacy(data, true)
+	require.Nil(t, err)
+
+	r := NewReader(list)
+	freq, ok := r.Frequency(10)
+	assert.True(t, ok)
+	assert.Equal(t, float32(legacyOccurrenceCount), freq)
+
+	freq, ok = r.Frequency(20)
+	assert.True(t, ok)
+	assert.Equal(t, float32(legacyOccurrenceCount), freq)
+}
+
+// legacyRow builds a pre-roaring flat-array row: a 4-byte checksum (not
+// verified by UnmarshalLegacy; its first byte, set from checksum, is what
+// IsLegacyRow compares against the version bytes), a 4-byte record count,
+// then either 4-byte docIDs or 4-byte docID/4-byte frequency pairs.
+func legacyRow(t *testing.T, checksum byte, docIDs []uint32, freqs []float32) []byte {
+	t.Helper()
+
+	buf := make([]byte, 0, 8+len(docIDs)*8)
+	buf = append(buf, checksum, 0, 0, 0)
+
+	count := make([]byte, 4)
+	binary.LittleEndian.PutUint32(count, uint32(len(docIDs)))
+	buf = append(buf, count...)
+
+	for i, docID := range docIDs {
+		id := make([]byte, 4)
+		binary.LittleEndian.PutUint32(id, docID)
+		buf = append(buf, id...)
+
+		if freqs == nil {
+			continue
+		}
+		f := make([]byte, 4)
+		binary.LittleEndian.PutUint32(f, math.Float32bits(freqs[i]))
+		buf = append(buf, f...)
+	}
+
+	return buf
+}
@@ -0,0 +1,157 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Pipeline is a named, ordered combination of a Tokenizer and TokenFilters
+// applied to text as it is indexed. Splitting analysis into a tokenizer
+// plus a filter chain -- rather than one hardcoded function per language,
+// as Analyzer.Text/String used to be -- lets classes mix and match, e.g.
+// run the same word tokenizer through either an English or a Russian
+// filter chain.
+type Pipeline struct {
+	Tokenizer Tokenizer
+	Filters   []TokenFilter
+}
+
+// Run tokenizes in and passes the result through every filter, in order.
+func (p *Pipeline) Run(in string) []Token {
+	tokens := p.Tokenizer.Tokenize(in)
+	for _, f := range p.Filters {
+		tokens = f.Filter(tokens)
+	}
+	return tokens
+}
+
+const (
+	// DefaultTextAnalyzer is the pipeline Analyzer.Text falls back to when a
+	// property has no explicit analyzer configured: lowercasing only, no
+	// stemming or stop words, matching this method's historic behavior.
+	DefaultTextAnalyzer = "text"
+
+	// DefaultStringAnalyzer is the pipeline Analyzer.String falls back to
+	// when a property has no explicit analyzer configured: whitespace
+	// splitting with no further normalization, matching this method's
+	// historic behavior.
+	DefaultStringAnalyzer = "string"
+
+	// EnglishAnalyzer lowercases, ASCII-folds, drops English stop words, and
+	// applies the Snowball English stemmer.
+	EnglishAnalyzer = "english"
+
+	// RussianAnalyzer lowercases, drops Russian stop words, and applies the
+	// Snowball Russian stemmer.
+	RussianAnalyzer = "russian"
+
+	// KeywordAnalyzer performs no splitting at all -- the whole property
+	// value is indexed as a single term.
+	KeywordAnalyzer = "keyword"
+)
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]*Pipeline{}
+
+	propertyAnalyzersMu sync.RWMutex
+	propertyAnalyzers   = map[string]string{}
+)
+
+// RegisterAnalyzer makes a pipeline available under name for later lookup
+// by GetAnalyzer, e.g. from a per-property analyzer name configured in the
+// schema. Registering under a name that already exists replaces it, which
+// is intentional: it lets callers override a built-in analyzer.
+func RegisterAnalyzer(name string, p *Pipeline) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[name] = p
+}
+
+// GetAnalyzer looks up a pipeline previously registered with
+// RegisterAnalyzer.
+func GetAnalyzer(name string) (*Pipeline, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	p, ok := registry[name]
+	return p, ok
+}
+
+// SetPropertyAnalyzer records that className's propName property should be
+// analyzed with the pipeline registered under analyzerName, overriding the
+// default text/string pipeline Analyzer.Object otherwise applies. It is
+// meant to be called once, when a class's schema is created or updated --
+// not from the object write path -- so an operator typo (an analyzer name
+// that was never passed to RegisterAnalyzer) is rejected at configuration
+// time instead of failing every subsequent write to that property.
+func SetPropertyAnalyzer(className, propName, analyzerName string) error {
+	if _, ok := GetAnalyzer(analyzerName); !ok {
+		return errors.Errorf("unknown analyzer %q", analyzerName)
+	}
+
+	propertyAnalyzersMu.Lock()
+	defer propertyAnalyzersMu.Unlock()
+	propertyAnalyzers[propertyAnalyzerKey(className, propName)] = analyzerName
+	return nil
+}
+
+// PropertyAnalyzer returns the analyzer name previously registered for
+// className's propName property via SetPropertyAnalyzer, if any.
+func PropertyAnalyzer(className, propName string) (string, bool) {
+	propertyAnalyzersMu.RLock()
+	defer propertyAnalyzersMu.RUnlock()
+	name, ok := propertyAnalyzers[propertyAnalyzerKey(className, propName)]
+	return name, ok
+}
+
+func propertyAnalyzerKey(className, propName string) string {
+	return className + "\x00" + propName
+}
+
+func init() {
+	RegisterAnalyzer(DefaultTextAnalyzer, &Pipeline{
+		Tokenizer: UnicodeWordTokenizer{},
+		Filters:   []TokenFilter{LowercaseFilter{}},
+	})
+
+	RegisterAnalyzer(DefaultStringAnalyzer, &Pipeline{
+		Tokenizer: WhitespaceTokenizer{},
+	})
+
+	RegisterAnalyzer(KeywordAnalyzer, &Pipeline{
+		Tokenizer: KeywordTokenizer{},
+	})
+
+	RegisterAnalyzer(EnglishAnalyzer, &Pipeline{
+		Tokenizer: UnicodeWordTokenizer{},
+		Filters: []TokenFilter{
+			LowercaseFilter{},
+			ASCIIFoldingFilter{},
+			StopwordFilter{Set: englishStopwords},
+			SnowballStemmerFilter{Language: "english"},
+			LengthFilter{Min: 1},
+		},
+	})
+
+	RegisterAnalyzer(RussianAnalyzer, &Pipeline{
+		Tokenizer: UnicodeWordTokenizer{},
+		Filters: []TokenFilter{
+			LowercaseFilter{},
+			StopwordFilter{Set: russianStopwords},
+			SnowballStemmerFilter{Language: "russian"},
+			LengthFilter{Min: 1},
+		},
+	})
+}
@@ -0,0 +1,150 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+import "math"
+
+// TermStats is the read-only view of a property's collection-level
+// statistics a Scorer needs to turn per-document term matches into a
+// relevance score -- how many documents carry the property, how long
+// they are on average, and how many of them contain a given term. None
+// of these can be derived from a single document's postings, which is
+// why they are tracked separately (see inverted/stats.Aggregate) rather
+// than stored alongside Countable/Property.
+type TermStats interface {
+	// DocCount returns how many documents have a value for propName.
+	DocCount(propName string) uint64
+	// AverageLength returns propName's mean document length across the
+	// collection.
+	AverageLength(propName string) float64
+	// DocFrequency returns how many documents contain term at least once
+	// in propName.
+	DocFrequency(propName, term string) uint64
+}
+
+// TermMatch is one query term's contribution to a single document's
+// score: how often it occurred in the document (Countable.TermCount) and
+// the document's total length in that property (Property.Length), both
+// captured at index time and looked up again at query time via the
+// segment store.
+type TermMatch struct {
+	Term      string
+	TermCount uint32
+	DocLength uint32
+}
+
+// Scorer ranks a document's relevance to a query given the term matches
+// found in it within propName. It is called once per candidate document;
+// higher is more relevant. Implementations are expected to be stateless
+// and safe for concurrent use, since a query fans a Scorer out across
+// every matching document.
+type Scorer interface {
+	Score(propName string, matches []TermMatch, stats TermStats) float64
+}
+
+// DefaultK1 and DefaultB are the term-frequency saturation and length-
+// normalization constants NewBM25Scorer falls back to for zero values,
+// matching the defaults most BM25 implementations (and the original
+// Okapi BM25 paper) use.
+const (
+	DefaultK1 = 1.2
+	DefaultB  = 0.75
+)
+
+// BM25Scorer implements Okapi BM25 (Robertson et al.), the standard
+// probabilistic ranking function for term-based full-text search: term
+// frequency contributes with diminishing returns controlled by K1,
+// documents longer than the property's average are penalized by an
+// amount controlled by B, and rarer terms are weighted more heavily via
+// inverse document frequency.
+type BM25Scorer struct {
+	K1 float64
+	B  float64
+}
+
+// NewBM25Scorer creates a BM25Scorer with the given k1/b, substituting
+// DefaultK1/DefaultB for zero values so callers can leave either
+// unconfigured rather than having to know the defaults themselves.
+func NewBM25Scorer(k1, b float64) *BM25Scorer {
+	if k1 == 0 {
+		k1 = DefaultK1
+	}
+	if b == 0 {
+		b = DefaultB
+	}
+	return &BM25Scorer{K1: k1, B: b}
+}
+
+// Score implements Scorer. If propName has no recoverable average length
+// (avgLen == 0, e.g. a property migrated straight from the pre-segment
+// bolt format, see Shard.rebuildStats), length normalization is disabled
+// rather than scoring every match 0 -- otherwise a migrated property
+// would read as empty and rank purely by docID until its documents are
+// re-indexed.
+func (sc *BM25Scorer) Score(propName string, matches []TermMatch, stats TermStats) float64 {
+	n := stats.DocCount(propName)
+	if n == 0 {
+		return 0
+	}
+	avgLen := stats.AverageLength(propName)
+
+	var score float64
+	for _, m := range matches {
+		df := stats.DocFrequency(propName, m.Term)
+		idf := math.Log(1 + (float64(n)-float64(df)+0.5)/(float64(df)+0.5))
+
+		tf := float64(m.TermCount)
+		norm := 1.0
+		if avgLen > 0 {
+			norm = 1 - sc.B + sc.B*(float64(m.DocLength)/avgLen)
+		}
+		score += idf * (tf * (sc.K1 + 1)) / (tf + sc.K1*norm)
+	}
+
+	return score
+}
+
+// TFIDFScorer implements classic TF-IDF: term frequency weighted by
+// inverse document frequency, without BM25's frequency saturation or
+// document-length normalization. It is a plug-in alternative for callers
+// that want simpler, more predictable scores and don't need K1/B tuned.
+type TFIDFScorer struct{}
+
+// Score implements Scorer.
+func (TFIDFScorer) Score(propName string, matches []TermMatch, stats TermStats) float64 {
+	n := stats.DocCount(propName)
+	if n == 0 {
+		return 0
+	}
+
+	var score float64
+	for _, m := range matches {
+		df := stats.DocFrequency(propName, m.Term)
+		if df == 0 {
+			continue
+		}
+		score += float64(m.TermCount) * math.Log(float64(n)/float64(df))
+	}
+
+	return score
+}
+
+// ConstantScorer scores every candidate equally, turning a query into a
+// plain boolean match with no ranking. It is a plug-in for callers that
+// only care about the match set, or for tests that need an ordering that
+// doesn't depend on collection statistics.
+type ConstantScorer struct{}
+
+// Score implements Scorer.
+func (ConstantScorer) Score(propName string, matches []TermMatch, stats TermStats) float64 {
+	return 1
+}
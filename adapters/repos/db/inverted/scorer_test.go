@@ -0,0 +1,71 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStats is a minimal TermStats double, so BM25Scorer can be tested
+// without a real stats.Aggregate or segment store.
+type fakeStats struct {
+	docCount  uint64
+	avgLength float64
+	docFreq   map[string]uint64
+}
+
+func (f fakeStats) DocCount(propName string) uint64       { return f.docCount }
+func (f fakeStats) AverageLength(propName string) float64 { return f.avgLength }
+func (f fakeStats) DocFrequency(propName, term string) uint64 {
+	return f.docFreq[term]
+}
+
+func TestBM25ScorerScoresZeroWithNoDocuments(t *testing.T) {
+	sc := NewBM25Scorer(0, 0)
+	stats := fakeStats{docCount: 0, avgLength: 10, docFreq: map[string]uint64{"foo": 0}}
+
+	score := sc.Score("title", []TermMatch{{Term: "foo", TermCount: 1, DocLength: 10}}, stats)
+	assert.Equal(t, float64(0), score)
+}
+
+func TestBM25ScorerRanksMoreFrequentTermsHigher(t *testing.T) {
+	sc := NewBM25Scorer(0, 0)
+	stats := fakeStats{docCount: 10, avgLength: 10, docFreq: map[string]uint64{"foo": 5}}
+
+	low := sc.Score("title", []TermMatch{{Term: "foo", TermCount: 1, DocLength: 10}}, stats)
+	high := sc.Score("title", []TermMatch{{Term: "foo", TermCount: 5, DocLength: 10}}, stats)
+
+	assert.Greater(t, high, low)
+}
+
+func TestBM25ScorerDisablesLengthNormalizationWithNoKnownAverageLength(t *testing.T) {
+	// a property migrated straight from the pre-segment bolt format has no
+	// recoverable average length (avgLength == 0); that must not collapse
+	// the score to 0, only skip length normalization.
+	sc := NewBM25Scorer(0, 0)
+	stats := fakeStats{docCount: 10, avgLength: 0, docFreq: map[string]uint64{"foo": 5}}
+
+	score := sc.Score("title", []TermMatch{{Term: "foo", TermCount: 2, DocLength: 0}}, stats)
+	assert.Greater(t, score, float64(0))
+}
+
+func TestBM25ScorerRareTermsScoreHigherThanCommonTerms(t *testing.T) {
+	sc := NewBM25Scorer(0, 0)
+	stats := fakeStats{docCount: 10, avgLength: 10, docFreq: map[string]uint64{"rare": 1, "common": 9}}
+
+	rare := sc.Score("title", []TermMatch{{Term: "rare", TermCount: 1, DocLength: 10}}, stats)
+	common := sc.Score("title", []TermMatch{{Term: "common", TermCount: 1, DocLength: 10}}, stats)
+
+	assert.Greater(t, rare, common)
+}
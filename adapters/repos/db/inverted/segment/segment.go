@@ -0,0 +1,857 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+// Package segment implements an append-only, segment-based inverted index,
+// replacing the previous scheme of rewriting one bolt row per term on
+// every insert. Each ingestion batch produces a new immutable Segment;
+// queries fan out across all live segments and merge results, while a
+// background goroutine folds small segments into larger ones (size-tiered
+// merging, the same approach used by scorch-style search engines).
+package segment
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/pkg/errors"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted/postings"
+)
+
+// Segment is one immutable chunk of the inverted index, built from a
+// single ingestion batch. Deletes never mutate a segment's term
+// dictionary; they only add to its tombstone bitmap, so a Segment can be
+// searched concurrently with no locking on the read path other than the
+// tombstone check.
+type Segment struct {
+	id         uint64
+	dict       map[string]*postings.List
+	tombstones *roaring.Bitmap
+	// docLengths holds, per property, each docID's token count in that
+	// property. It is the per-document half of the statistics a BM25
+	// scorer needs; the collection-level half (document count, average
+	// length, document frequency per term) is tracked separately, see
+	// Shard's stats.Aggregate.
+	docLengths map[string]map[uint32]uint32
+	mu         sync.RWMutex
+}
+
+// NewSegment builds an immutable segment out of one ingestion batch. props
+// and docIDs are index-aligned: props[i] holds the already-analyzed
+// per-property terms for docIDs[i].
+func NewSegment(id uint64, props [][]inverted.Property, docIDs []uint32) *Segment {
+	seg := &Segment{
+		id:         id,
+		dict:       make(map[string]*postings.List),
+		tombstones: roaring.NewBitmap(),
+		docLengths: make(map[string]map[uint32]uint32),
+	}
+
+	for i, objProps := range props {
+		docID := docIDs[i]
+		for _, prop := range objProps {
+			for _, item := range prop.Items {
+				list := seg.listFor(prop.Name, item.Data)
+				w := postings.NewWriter(list)
+				if prop.HasFrequency {
+					w.AddWithFrequency(docID, float32(item.TermCount), item.Positions)
+				} else {
+					w.Add(docID)
+				}
+			}
+
+			if prop.HasFrequency {
+				seg.setDocLength(prop.Name, docID, uint32(prop.Length()))
+			}
+		}
+	}
+
+	return seg
+}
+
+func (s *Segment) setDocLength(propName string, docID uint32, length uint32) {
+	lengths, ok := s.docLengths[propName]
+	if !ok {
+		lengths = make(map[uint32]uint32)
+		s.docLengths[propName] = lengths
+	}
+	lengths[docID] = length
+}
+
+// DocLength returns the token count docID's propName property had at
+// index time, needed by a BM25 scorer to normalize term frequency against
+// document length. A tombstoned docID returns false, the same as if it had
+// never been indexed in this segment -- otherwise a re-added docID (delete
+// tombstones it here, then a later batch writes a new segment for it)
+// would report this segment's now-stale length instead of its current one.
+func (s *Segment) DocLength(propName string, docID uint32) (uint32, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.tombstones.Contains(docID) {
+		return 0, false
+	}
+
+	length, ok := s.docLengths[propName][docID]
+	return length, ok
+}
+
+// Terms returns every distinct term indexed for propName in this segment,
+// used to rebuild collection-level statistics (e.g. document frequency)
+// from scratch.
+func (s *Segment) Terms(propName string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := propName + "\x00"
+	var out []string
+	for key := range s.dict {
+		if term := strings.TrimPrefix(key, prefix); term != key {
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+func (s *Segment) listFor(propName string, term []byte) *postings.List {
+	key := dictKey(propName, term)
+	list, ok := s.dict[key]
+	if !ok {
+		list = postings.NewList()
+		s.dict[key] = list
+	}
+	return list
+}
+
+func dictKey(propName string, term []byte) string {
+	return propName + "\x00" + string(term)
+}
+
+// ID identifies the segment for merge-tier bookkeeping and, once flushed,
+// for naming its on-disk file.
+func (s *Segment) ID() uint64 {
+	return s.id
+}
+
+// Search returns a reader over the docIDs matching propName/term in this
+// segment, with tombstoned docIDs filtered out. It returns nil if the term
+// does not occur in the segment at all.
+func (s *Segment) Search(propName string, term []byte) *postings.Reader {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list, ok := s.dict[dictKey(propName, term)]
+	if !ok {
+		return nil
+	}
+
+	reader := postings.NewReader(list)
+	if s.tombstones.IsEmpty() {
+		return reader
+	}
+
+	live := postings.NewList()
+	w := postings.NewWriter(live)
+	it := reader.Iterator()
+	for it.HasNext() {
+		docID := it.Next()
+		if s.tombstones.Contains(docID) {
+			continue
+		}
+		if freq, ok := reader.Frequency(docID); ok {
+			positions, _ := reader.Positions(docID)
+			w.AddWithFrequency(docID, freq, positions)
+		} else {
+			w.Add(docID)
+		}
+	}
+
+	return postings.NewReader(live)
+}
+
+// Delete tombstones docID, hiding it from future Search calls. The
+// underlying posting lists are left untouched until the segment is next
+// merged.
+func (s *Segment) Delete(docID uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tombstones.Add(docID)
+}
+
+// Size returns the number of live (non-tombstoned) postings across all
+// terms in the segment, used by Store's size-tiered merge policy as a
+// cheap proxy for on-disk size.
+func (s *Segment) Size() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total uint64
+	for _, list := range s.dict {
+		it := postings.NewReader(list).Iterator()
+		for it.HasNext() {
+			if !s.tombstones.Contains(it.Next()) {
+				total++
+			}
+		}
+	}
+	return total
+}
+
+// marshal serializes the segment for Store.flush: its id, its term
+// dictionary (each posting list via postings.Marshal), its tombstone
+// bitmap, and its per-property doc lengths.
+func (s *Segment) marshal() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	buf := bytes.NewBuffer(nil)
+	varintBuf := make([]byte, binary.MaxVarintLen64)
+	writeUvarint := func(v uint64) {
+		n := binary.PutUvarint(varintBuf, v)
+		buf.Write(varintBuf[:n])
+	}
+	writeBytes := func(b []byte) {
+		writeUvarint(uint64(len(b)))
+		buf.Write(b)
+	}
+
+	writeUvarint(s.id)
+
+	writeUvarint(uint64(len(s.dict)))
+	for key, list := range s.dict {
+		data, err := postings.Marshal(list)
+		if err != nil {
+			return nil, errors.Wrapf(err, "marshal posting list %q", key)
+		}
+		writeBytes([]byte(key))
+		writeBytes(data)
+	}
+
+	tombstoneBuf := bytes.NewBuffer(nil)
+	if _, err := s.tombstones.WriteTo(tombstoneBuf); err != nil {
+		return nil, errors.Wrap(err, "write tombstones")
+	}
+	writeBytes(tombstoneBuf.Bytes())
+
+	writeUvarint(uint64(len(s.docLengths)))
+	for propName, lengths := range s.docLengths {
+		writeBytes([]byte(propName))
+		writeUvarint(uint64(len(lengths)))
+		for docID, length := range lengths {
+			writeUvarint(uint64(docID))
+			writeUvarint(uint64(length))
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalSegment parses a segment file previously written by
+// Segment.marshal.
+func unmarshalSegment(data []byte) (*Segment, error) {
+	r := bytes.NewReader(data)
+	readUvarint := func() (uint64, error) {
+		return binary.ReadUvarint(r)
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUvarint()
+		if err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
+
+	id, err := readUvarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "read segment id")
+	}
+
+	dictLen, err := readUvarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "read dict length")
+	}
+
+	dict := make(map[string]*postings.List, dictLen)
+	for i := uint64(0); i < dictLen; i++ {
+		key, err := readBytes()
+		if err != nil {
+			return nil, errors.Wrap(err, "read dict key")
+		}
+		listData, err := readBytes()
+		if err != nil {
+			return nil, errors.Wrap(err, "read posting list")
+		}
+		list, err := postings.Unmarshal(listData)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unmarshal posting list %q", key)
+		}
+		dict[string(key)] = list
+	}
+
+	tombstoneData, err := readBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "read tombstones")
+	}
+	tombstones := roaring.NewBitmap()
+	if len(tombstoneData) > 0 {
+		if _, err := tombstones.ReadFrom(bytes.NewReader(tombstoneData)); err != nil {
+			return nil, errors.Wrap(err, "unmarshal tombstones")
+		}
+	}
+
+	propCount, err := readUvarint()
+	if err != nil {
+		return nil, errors.Wrap(err, "read doc length property count")
+	}
+
+	docLengths := make(map[string]map[uint32]uint32, propCount)
+	for i := uint64(0); i < propCount; i++ {
+		propName, err := readBytes()
+		if err != nil {
+			return nil, errors.Wrap(err, "read doc length property name")
+		}
+
+		n, err := readUvarint()
+		if err != nil {
+			return nil, errors.Wrap(err, "read doc length count")
+		}
+
+		lengths := make(map[uint32]uint32, n)
+		for j := uint64(0); j < n; j++ {
+			docID, err := readUvarint()
+			if err != nil {
+				return nil, errors.Wrap(err, "read doc id")
+			}
+			length, err := readUvarint()
+			if err != nil {
+				return nil, errors.Wrap(err, "read doc length")
+			}
+			lengths[uint32(docID)] = uint32(length)
+		}
+		docLengths[string(propName)] = lengths
+	}
+
+	return &Segment{
+		id:         id,
+		dict:       dict,
+		tombstones: tombstones,
+		docLengths: docLengths,
+	}, nil
+}
+
+// Store owns the set of live segments for one shard's inverted index. New
+// segments are appended by NewSegment as ingestion batches complete;
+// Search fans out across all of them; Merge folds small segments together
+// following a size-tiered plan. Store does not schedule Merge itself —
+// callers (typically a background goroutine owned by Shard) call it
+// periodically.
+type Store struct {
+	mu           sync.RWMutex
+	segments     []*Segment
+	nextID       uint64
+	dir          string
+	minTierCount int
+}
+
+// NewStore creates a segment store that persists its files under dir. Call
+// Open to load whatever segments an earlier run already flushed there. An
+// empty dir opts the store out of persistence entirely (e.g. for tests):
+// flush and Open both become no-ops, and segments live only in memory.
+func NewStore(dir string) *Store {
+	return &Store{dir: dir, minTierCount: 4}
+}
+
+// segmentFileName returns the filename a flushed segment with id is stored
+// under, relative to the store's directory.
+func segmentFileName(id uint64) string {
+	return fmt.Sprintf("segment-%d.db", id)
+}
+
+// parseSegmentFileName extracts a segment id from a filename previously
+// produced by segmentFileName, or ok=false for anything else found in the
+// directory (e.g. a stray ".tmp" file left by an interrupted flush).
+func parseSegmentFileName(name string) (id uint64, ok bool) {
+	const prefix, suffix = "segment-", ".db"
+	if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, suffix) {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, prefix), suffix), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// Open loads every segment file previously written by flush under the
+// store's directory, so a shard's inverted index survives a restart
+// instead of coming back empty. It is meant to be called once, right
+// after NewStore, before any segment is written.
+func (s *Store) Open() error {
+	if s.dir == "" {
+		return nil
+	}
+
+	entries, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "read segment directory %q", s.dir)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range entries {
+		id, ok := parseSegmentFileName(entry.Name())
+		if !ok {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "read segment file %q", entry.Name())
+		}
+
+		seg, err := unmarshalSegment(data)
+		if err != nil {
+			return errors.Wrapf(err, "unmarshal segment file %q", entry.Name())
+		}
+
+		s.segments = append(s.segments, seg)
+		if id >= s.nextID {
+			s.nextID = id + 1
+		}
+	}
+
+	return nil
+}
+
+// flush persists seg to a file under the store's directory so it survives
+// a restart (see Open). It is a no-op for a store created with an empty
+// dir.
+func (s *Store) flush(seg *Segment) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	data, err := seg.marshal()
+	if err != nil {
+		return errors.Wrapf(err, "marshal segment %d", seg.id)
+	}
+
+	path := filepath.Join(s.dir, segmentFileName(seg.id))
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.Wrapf(err, "write segment %d", seg.id)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.Wrapf(err, "rename segment %d into place", seg.id)
+	}
+	return nil
+}
+
+// removeSegmentFile deletes seg's flushed file, e.g. once it has been
+// folded into a merge and dropped from the live set. A file that's already
+// gone (the store never flushed, or crashed between flush and this call)
+// is not an error -- the space was already reclaimed, or never used.
+func (s *Store) removeSegmentFile(id uint64) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	if err := os.Remove(filepath.Join(s.dir, segmentFileName(id))); err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "remove segment %d", id)
+	}
+	return nil
+}
+
+// DictKey mirrors the key format posting lists are stored under inside a
+// segment's term dictionary. It is exported so migration code that builds
+// a segment directly from legacy bolt rows doesn't need to duplicate the
+// format.
+func DictKey(propName string, term []byte) string {
+	return dictKey(propName, term)
+}
+
+// NewSegmentFromDict builds a segment directly from an already-assembled
+// term dictionary, flushes it, and adds it to the live set. It is used by
+// the one-shot migration that folds legacy bolt-backed postings into the
+// segment store the first time a shard created before this upgrade is
+// opened. Migrated rows predate per-document length tracking, so the
+// resulting segment has no doc lengths recorded until its documents are
+// re-indexed.
+//
+// flush's file I/O runs without the store lock held -- only id allocation
+// and the final append to the live set take it -- so one write's disk
+// latency doesn't stall every concurrent Search.
+func (s *Store) NewSegmentFromDict(dict map[string]*postings.List) (*Segment, error) {
+	id := s.allocateID()
+
+	seg := &Segment{
+		id:         id,
+		dict:       dict,
+		tombstones: roaring.NewBitmap(),
+		docLengths: make(map[string]map[uint32]uint32),
+	}
+
+	if err := s.flush(seg); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.segments = append(s.segments, seg)
+	s.mu.Unlock()
+	return seg, nil
+}
+
+// NewSegment builds a new segment from one ingestion batch, flushes it, and
+// adds it to the live set. See NewSegmentFromDict for why flush runs
+// outside the store lock.
+func (s *Store) NewSegment(props [][]inverted.Property, docIDs []uint32) (*Segment, error) {
+	id := s.allocateID()
+
+	seg := NewSegment(id, props, docIDs)
+	if err := s.flush(seg); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.segments = append(s.segments, seg)
+	s.mu.Unlock()
+	return seg, nil
+}
+
+// allocateID hands out the next segment id under the store lock, without
+// holding it for the rest of the segment's construction or flush.
+func (s *Store) allocateID() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.nextID
+	s.nextID++
+	return id
+}
+
+// Search fans out across every live segment and merges the results with a
+// plain bitmap union: a docID only ever lives in the single segment
+// produced by the batch that ingested it, so segments never overlap and a
+// union is all that's needed to reconstruct the full match set.
+func (s *Store) Search(propName string, term []byte) *roaring.Bitmap {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	result := roaring.NewBitmap()
+	for _, seg := range s.segments {
+		reader := seg.Search(propName, term)
+		if reader == nil {
+			continue
+		}
+		result.Or(reader.Bitmap())
+	}
+
+	return result
+}
+
+// SearchWithPositions is like Search, but additionally returns each
+// matching docID's token positions for propName/term, for use by phrase
+// queries.
+func (s *Store) SearchWithPositions(propName string, term []byte) map[uint32][]uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := map[uint32][]uint32{}
+	for _, seg := range s.segments {
+		reader := seg.Search(propName, term)
+		if reader == nil {
+			continue
+		}
+
+		it := reader.Iterator()
+		for it.HasNext() {
+			docID := it.Next()
+			if positions, ok := reader.Positions(docID); ok {
+				out[docID] = positions
+			}
+		}
+	}
+
+	return out
+}
+
+// SearchWithFrequencies is like Search, but additionally returns each
+// matching docID's raw term count for propName/term, needed by a Scorer
+// (see inverted.Scorer) to compute relevance.
+func (s *Store) SearchWithFrequencies(propName string, term []byte) map[uint32]float32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := map[uint32]float32{}
+	for _, seg := range s.segments {
+		reader := seg.Search(propName, term)
+		if reader == nil {
+			continue
+		}
+
+		it := reader.Iterator()
+		for it.HasNext() {
+			docID := it.Next()
+			if freq, ok := reader.Frequency(docID); ok {
+				out[docID] = freq
+			}
+		}
+	}
+
+	return out
+}
+
+// DocLength returns docID's token count for propName, needed by a BM25
+// scorer to normalize term frequency against document length (the first
+// live segment that has it wins).
+func (s *Store) DocLength(propName string, docID uint32) (uint32, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, seg := range s.segments {
+		if length, ok := seg.DocLength(propName, docID); ok {
+			return length, true
+		}
+	}
+	return 0, false
+}
+
+// AllTerms returns every distinct term indexed for propName across all
+// live segments, used to rebuild collection-level statistics from
+// scratch.
+func (s *Store) AllTerms(propName string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := map[string]struct{}{}
+	var out []string
+	for _, seg := range s.segments {
+		for _, term := range seg.Terms(propName) {
+			if _, ok := seen[term]; ok {
+				continue
+			}
+			seen[term] = struct{}{}
+			out = append(out, term)
+		}
+	}
+	return out
+}
+
+// AllDocLengths returns every docID's token count for propName across all
+// live segments, used to rebuild collection-level statistics from
+// scratch.
+func (s *Store) AllDocLengths(propName string) map[uint32]uint32 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := map[uint32]uint32{}
+	for _, seg := range s.segments {
+		seg.mu.RLock()
+		for docID, length := range seg.docLengths[propName] {
+			if seg.tombstones.Contains(docID) {
+				continue
+			}
+			out[docID] = length
+		}
+		seg.mu.RUnlock()
+	}
+	return out
+}
+
+// AllDocIDs returns the union of every live docID with at least one
+// posting for propName across all live segments. Unlike AllDocLengths,
+// this doesn't depend on per-document length having been recorded, so it
+// still reflects documents migrated straight from the pre-segment bolt
+// format (see Shard.rebuildStats), which predate that bookkeeping.
+func (s *Store) AllDocIDs(propName string) *roaring.Bitmap {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := propName + "\x00"
+	union := roaring.NewBitmap()
+	for _, seg := range s.segments {
+		seg.mu.RLock()
+		for key, list := range seg.dict {
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			it := postings.NewReader(list).Iterator()
+			for it.HasNext() {
+				docID := it.Next()
+				if seg.tombstones.Contains(docID) {
+					continue
+				}
+				union.Add(docID)
+			}
+		}
+		seg.mu.RUnlock()
+	}
+	return union
+}
+
+// Delete tombstones docID across every live segment. Since the store
+// doesn't track which segment ingested which docID, this is O(segment
+// count); the space is reclaimed the next time an affected segment
+// participates in a merge.
+func (s *Store) Delete(docID uint32) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, seg := range s.segments {
+		seg.Delete(docID)
+	}
+}
+
+// Merge runs a single size-tiered merge pass: segments are bucketed by
+// order-of-magnitude size, and any bucket holding at least minTierCount
+// segments is combined into one new segment with tombstoned docIDs
+// physically dropped. It is safe to call Merge repeatedly, e.g. from a
+// ticker-driven background goroutine; a pass with nothing to merge is a
+// cheap no-op.
+//
+// Building and flushing a merged segment runs without the store lock
+// held, the same as NewSegment -- only the snapshot of current segments,
+// id allocation, and the final swap into the live set take it -- so a
+// merge's I/O doesn't stall concurrent Search/NewSegment calls for its
+// whole duration.
+func (s *Store) Merge(ctx context.Context) error {
+	s.mu.RLock()
+	tiers := map[int][]*Segment{}
+	for _, seg := range s.segments {
+		tier := sizeTier(seg.Size())
+		tiers[tier] = append(tiers[tier], seg)
+	}
+	s.mu.RUnlock()
+
+	for _, segs := range tiers {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if len(segs) < s.minTierCount {
+			continue
+		}
+
+		merged := mergeSegments(s.allocateID(), segs)
+		if err := s.flush(merged); err != nil {
+			return errors.Wrapf(err, "flush merged segment %d", merged.id)
+		}
+
+		s.mu.Lock()
+		s.segments = replace(s.segments, segs, merged)
+		s.mu.Unlock()
+
+		for _, seg := range segs {
+			if err := s.removeSegmentFile(seg.id); err != nil {
+				return errors.Wrapf(err, "remove merged-away segment %d", seg.id)
+			}
+		}
+	}
+
+	return nil
+}
+
+// sizeTier buckets a segment size into an order-of-magnitude tier (base 4),
+// so segments of roughly similar size are considered for merging together,
+// the same idea LSM-tree-style stores use to keep merge cost amortized.
+func sizeTier(size uint64) int {
+	tier := 0
+	for size > 0 {
+		size /= 4
+		tier++
+	}
+	return tier
+}
+
+// mergeSegments combines segs into one new segment with id, physically
+// dropping any docID tombstoned in the segment that carried it.
+func mergeSegments(id uint64, segs []*Segment) *Segment {
+	merged := &Segment{
+		id:         id,
+		dict:       make(map[string]*postings.List),
+		tombstones: roaring.NewBitmap(),
+		docLengths: make(map[string]map[uint32]uint32),
+	}
+
+	for _, seg := range segs {
+		seg.mu.RLock()
+		for key, list := range seg.dict {
+			reader := postings.NewReader(list)
+			dst, ok := merged.dict[key]
+			if !ok {
+				dst = postings.NewList()
+				merged.dict[key] = dst
+			}
+			w := postings.NewWriter(dst)
+
+			it := reader.Iterator()
+			for it.HasNext() {
+				docID := it.Next()
+				if seg.tombstones.Contains(docID) {
+					continue
+				}
+				if freq, ok := reader.Frequency(docID); ok {
+					positions, _ := reader.Positions(docID)
+					w.AddWithFrequency(docID, freq, positions)
+				} else {
+					w.Add(docID)
+				}
+			}
+		}
+
+		for propName, lengths := range seg.docLengths {
+			for docID, length := range lengths {
+				if seg.tombstones.Contains(docID) {
+					continue
+				}
+				merged.setDocLength(propName, docID, length)
+			}
+		}
+		seg.mu.RUnlock()
+	}
+
+	return merged
+}
+
+func replace(all, old []*Segment, merged *Segment) []*Segment {
+	oldIDs := make(map[uint64]bool, len(old))
+	for _, seg := range old {
+		oldIDs[seg.id] = true
+	}
+
+	out := make([]*Segment, 0, len(all)-len(old)+1)
+	for _, seg := range all {
+		if oldIDs[seg.id] {
+			continue
+		}
+		out = append(out, seg)
+	}
+
+	return append(out, merged)
+}
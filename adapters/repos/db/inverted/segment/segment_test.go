@@ -0,0 +1,96 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package segment
+
+import (
+	"testing"
+
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func textProps(value string, termCounts map[string]uint32) []inverted.Property {
+	items := make([]inverted.Countable, 0, len(termCounts))
+	for term, count := range termCounts {
+		items = append(items, inverted.Countable{Data: []byte(term), TermCount: count})
+	}
+	return []inverted.Property{{Name: "description", Items: items, HasFrequency: true}}
+}
+
+func TestSegmentMarshalUnmarshalRoundTrip(t *testing.T) {
+	props := [][]inverted.Property{
+		textProps("doc0", map[string]uint32{"foo": 2, "bar": 1}),
+		textProps("doc1", map[string]uint32{"foo": 1}),
+	}
+	seg := NewSegment(42, props, []uint32{0, 1})
+	seg.Delete(1)
+
+	data, err := seg.marshal()
+	require.Nil(t, err)
+
+	got, err := unmarshalSegment(data)
+	require.Nil(t, err)
+
+	assert.Equal(t, uint64(42), got.ID())
+
+	length, ok := got.DocLength("description", 0)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(3), length)
+
+	// docID 1 was tombstoned before marshaling, so it round-trips as
+	// deleted rather than live.
+	_, ok = got.DocLength("description", 1)
+	assert.False(t, ok)
+
+	reader := got.Search("description", []byte("foo"))
+	require.NotNil(t, reader)
+	assert.True(t, reader.Contains(0))
+	assert.False(t, reader.Contains(1))
+}
+
+func TestStoreOpenReloadsFlushedSegments(t *testing.T) {
+	dir := t.TempDir()
+
+	store := NewStore(dir)
+	require.Nil(t, store.Open())
+
+	props := [][]inverted.Property{textProps("doc0", map[string]uint32{"foo": 1})}
+	_, err := store.NewSegment(props, []uint32{0})
+	require.Nil(t, err)
+
+	reloaded := NewStore(dir)
+	require.Nil(t, reloaded.Open())
+
+	result := reloaded.Search("description", []byte("foo"))
+	assert.True(t, result.Contains(0))
+}
+
+func TestMergeDropsTombstonedDocIDs(t *testing.T) {
+	segA := NewSegment(0, [][]inverted.Property{
+		textProps("doc0", map[string]uint32{"foo": 1}),
+	}, []uint32{0})
+	segB := NewSegment(1, [][]inverted.Property{
+		textProps("doc1", map[string]uint32{"foo": 1}),
+	}, []uint32{1})
+	segB.Delete(1)
+
+	merged := mergeSegments(2, []*Segment{segA, segB})
+
+	reader := merged.Search("description", []byte("foo"))
+	require.NotNil(t, reader)
+	assert.True(t, reader.Contains(0))
+	assert.False(t, reader.Contains(1))
+
+	_, ok := merged.DocLength("description", 1)
+	assert.False(t, ok)
+}
@@ -0,0 +1,167 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+// Package stats tracks the collection-level term statistics an
+// inverted.Scorer needs at query time -- how many documents exist for a
+// property, how long they are on average, and how many contain a given
+// term -- none of which can be derived from a single posting list the
+// way per-document term frequency can.
+package stats
+
+import "sync"
+
+// Aggregate holds, per property, the collection-level statistics a
+// Scorer needs. It is updated incrementally as documents are indexed
+// (AddDocument) and can be recomputed wholesale by Rebuild when postings
+// change out from under it by some other means, e.g. the bolt-to-segment
+// migration. It is safe for concurrent use.
+type Aggregate struct {
+	mu          sync.RWMutex
+	docCount    map[string]uint64
+	lengthSum   map[string]uint64
+	lengthCount map[string]uint64
+	docFreq     map[string]map[string]uint64
+}
+
+// NewAggregate creates an empty Aggregate.
+func NewAggregate() *Aggregate {
+	return &Aggregate{
+		docCount:    map[string]uint64{},
+		lengthSum:   map[string]uint64{},
+		lengthCount: map[string]uint64{},
+		docFreq:     map[string]map[string]uint64{},
+	}
+}
+
+// AddDocument folds one newly indexed document into propName's
+// statistics: it occurred, its length was length, and it contained each
+// of terms (already deduplicated, e.g. Property.Items) at least once.
+// Call this once per document per frequency-tracked property, at the
+// same time its postings are written.
+func (a *Aggregate) AddDocument(propName string, length uint32, terms []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.docCount[propName]++
+	a.lengthSum[propName] += uint64(length)
+	a.lengthCount[propName]++
+
+	freq, ok := a.docFreq[propName]
+	if !ok {
+		freq = map[string]uint64{}
+		a.docFreq[propName] = freq
+	}
+	for _, term := range terms {
+		freq[term]++
+	}
+}
+
+// RemoveDocument undoes an earlier AddDocument call for the same propName,
+// length and terms, e.g. when the document is deleted. Without this,
+// repeated update (delete-then-reindex) cycles would inflate docCount and
+// docFreq without bound, since deletes only tombstone postings rather than
+// removing them (see inverted/segment.Store.Delete). Counters are clamped
+// at zero rather than going negative, since a document removed this way
+// may have been recorded by Rebuild instead of AddDocument and so not
+// contributed to every counter it's being subtracted from.
+func (a *Aggregate) RemoveDocument(propName string, length uint32, terms []string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.docCount[propName] = saturatingSub(a.docCount[propName], 1)
+	a.lengthSum[propName] = saturatingSub(a.lengthSum[propName], uint64(length))
+	a.lengthCount[propName] = saturatingSub(a.lengthCount[propName], 1)
+
+	freq, ok := a.docFreq[propName]
+	if !ok {
+		return
+	}
+	for _, term := range terms {
+		freq[term] = saturatingSub(freq[term], 1)
+	}
+}
+
+func saturatingSub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
+
+// DocCount returns how many documents have a value for propName.
+func (a *Aggregate) DocCount(propName string) uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.docCount[propName]
+}
+
+// AverageLength returns propName's mean document length across the
+// collection, or 0 if no document has a recorded length yet. This is
+// tracked separately from DocCount (lengthCount rather than docCount)
+// since Rebuild may know a property's true live document count without
+// knowing every one of those documents' lengths, e.g. rows migrated from
+// the pre-segment bolt format (see Shard.rebuildStats).
+func (a *Aggregate) AverageLength(propName string) float64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	n := a.lengthCount[propName]
+	if n == 0 {
+		return 0
+	}
+	return float64(a.lengthSum[propName]) / float64(n)
+}
+
+// DocFrequency returns how many documents contain term at least once in
+// propName.
+func (a *Aggregate) DocFrequency(propName, term string) uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.docFreq[propName][term]
+}
+
+// Rebuild replaces propName's statistics wholesale from an authoritative
+// snapshot of its current live postings: docCount is the property's true
+// live document count, docLengths is every live docID's token count that
+// is actually known (it may cover fewer documents than docCount -- see
+// below), terms is every distinct term indexed for the property, and
+// docFreq(term) must return that term's live document frequency. It is
+// meant for postings that changed by a means other than AddDocument, e.g.
+// the one-shot bolt-to-segment migration folding rows that predate this
+// package's incremental bookkeeping.
+//
+// docCount is taken as given rather than derived from len(docLengths):
+// a document migrated straight from the pre-segment bolt format has no
+// recorded length (that per-document statistic was never tracked there),
+// but it still counts towards docCount and DocFrequency. Its length is
+// simply not recoverable, so it's left out of the AverageLength
+// computation rather than assumed to be 0 -- callers that can only derive
+// docCount from postings cardinality should still pass docLengths as-is.
+func (a *Aggregate) Rebuild(propName string, docCount uint64, docLengths map[uint32]uint32, terms []string, docFreq func(term string) uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var lengthSum uint64
+	for _, length := range docLengths {
+		lengthSum += uint64(length)
+	}
+	a.docCount[propName] = docCount
+	a.lengthSum[propName] = lengthSum
+	a.lengthCount[propName] = uint64(len(docLengths))
+
+	freq := make(map[string]uint64, len(terms))
+	for _, term := range terms {
+		freq[term] = docFreq(term)
+	}
+	a.docFreq[propName] = freq
+}
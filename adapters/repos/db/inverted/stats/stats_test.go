@@ -0,0 +1,79 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package stats
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddDocumentAccumulates(t *testing.T) {
+	a := NewAggregate()
+	a.AddDocument("title", 4, []string{"foo", "bar"})
+	a.AddDocument("title", 6, []string{"foo"})
+
+	assert.Equal(t, uint64(2), a.DocCount("title"))
+	assert.Equal(t, float64(5), a.AverageLength("title"))
+	assert.Equal(t, uint64(2), a.DocFrequency("title", "foo"))
+	assert.Equal(t, uint64(1), a.DocFrequency("title", "bar"))
+}
+
+func TestRemoveDocumentUndoesAddDocument(t *testing.T) {
+	a := NewAggregate()
+	a.AddDocument("title", 4, []string{"foo", "bar"})
+	a.AddDocument("title", 6, []string{"foo"})
+
+	a.RemoveDocument("title", 4, []string{"foo", "bar"})
+
+	assert.Equal(t, uint64(1), a.DocCount("title"))
+	assert.Equal(t, float64(6), a.AverageLength("title"))
+	assert.Equal(t, uint64(1), a.DocFrequency("title", "foo"))
+	assert.Equal(t, uint64(0), a.DocFrequency("title", "bar"))
+}
+
+func TestRemoveDocumentSaturatesAtZero(t *testing.T) {
+	a := NewAggregate()
+
+	// removing a document that was never added (e.g. one only ever
+	// recorded via Rebuild, not AddDocument) must not wrap the counters
+	// negative.
+	a.RemoveDocument("title", 4, []string{"foo"})
+
+	assert.Equal(t, uint64(0), a.DocCount("title"))
+	assert.Equal(t, float64(0), a.AverageLength("title"))
+	assert.Equal(t, uint64(0), a.DocFrequency("title", "foo"))
+}
+
+func TestRebuildDocCountIsIndependentOfKnownLengths(t *testing.T) {
+	a := NewAggregate()
+
+	// a property migrated from the pre-segment bolt format: 3 live
+	// documents, but lengths are only known for 1 of them.
+	docLengths := map[uint32]uint32{1: 10}
+	a.Rebuild("title", 3, docLengths, []string{"foo"}, func(term string) uint64 {
+		return 2
+	})
+
+	assert.Equal(t, uint64(3), a.DocCount("title"))
+	assert.Equal(t, float64(10), a.AverageLength("title"))
+	assert.Equal(t, uint64(2), a.DocFrequency("title", "foo"))
+}
+
+func TestRebuildWithNoKnownLengthsLeavesAverageLengthZero(t *testing.T) {
+	a := NewAggregate()
+
+	a.Rebuild("title", 5, map[uint32]uint32{}, nil, func(term string) uint64 { return 0 })
+
+	assert.Equal(t, uint64(5), a.DocCount("title"))
+	assert.Equal(t, float64(0), a.AverageLength("title"))
+}
@@ -0,0 +1,34 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+// russianStopwords are the terms dropped by the "russian" analyzer, based
+// on the standard Snowball Russian stop word list.
+var russianStopwords = setOf(
+	"и", "в", "во", "не", "что", "он", "на", "я", "с", "со", "как", "а",
+	"то", "все", "она", "так", "его", "но", "да", "ты", "к", "у", "же",
+	"вы", "за", "бы", "по", "только", "ее", "мне", "было", "вот", "от",
+	"меня", "еще", "нет", "о", "из", "ему", "теперь", "когда", "даже",
+	"ну", "вдруг", "ли", "если", "уже", "или", "ни", "быть", "был",
+	"него", "до", "вас", "нибудь", "опять", "уж", "вам", "сказал", "ведь",
+	"там", "потом", "себя", "ничего", "им", "для", "мы", "тебя", "их",
+	"чем", "была", "сам", "чтоб", "без", "будто", "человек", "чего",
+	"раз", "тоже", "себе", "под", "будет", "ж", "тогда", "кто", "этот",
+	"того", "потому", "этого", "какой", "совсем", "ним", "здесь", "этом",
+	"один", "почти", "мой", "тем", "чтобы", "нее", "были", "куда", "зачем",
+	"всех", "никогда", "можно", "при", "наконец", "два", "об", "другой",
+	"хоть", "после", "над", "больше", "тот", "через", "эти", "нас", "про",
+	"всего", "них", "какая", "много", "разве", "три", "эту", "моя",
+	"впрочем", "хорошо", "свою", "этой", "перед", "иногда", "лучше",
+	"чуть", "том", "нельзя", "такой", "им", "более", "всегда", "конечно",
+	"всю", "между",
+)
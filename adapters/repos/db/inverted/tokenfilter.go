@@ -0,0 +1,129 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kljensen/snowball"
+	"golang.org/x/text/unicode/norm"
+)
+
+// TokenFilter transforms a token stream produced by a Tokenizer, e.g.
+// lowercasing, dropping stop words, or stemming. Filters may drop tokens
+// entirely (stop words, tokens outside a length range) by returning fewer
+// tokens than they were given; order matters, since e.g. a stemmer should
+// generally run after lowercasing.
+type TokenFilter interface {
+	Filter(in []Token) []Token
+}
+
+// LowercaseFilter lowercases every token.
+type LowercaseFilter struct{}
+
+func (LowercaseFilter) Filter(in []Token) []Token {
+	out := make([]Token, len(in))
+	for i, tok := range in {
+		out[i] = Token{Term: []byte(strings.ToLower(string(tok.Term)))}
+	}
+	return out
+}
+
+// ASCIIFoldingFilter strips diacritics (e.g. "café" -> "cafe") via Unicode
+// NFKD normalization, so accented and unaccented spellings of the same
+// word match.
+type ASCIIFoldingFilter struct{}
+
+func (ASCIIFoldingFilter) Filter(in []Token) []Token {
+	out := make([]Token, len(in))
+	for i, tok := range in {
+		out[i] = Token{Term: []byte(foldASCII(string(tok.Term)))}
+	}
+	return out
+}
+
+func foldASCII(in string) string {
+	decomposed := norm.NFKD.String(in)
+	folded := make([]rune, 0, len(decomposed))
+	for _, r := range decomposed {
+		if unicode.Is(unicode.Mn, r) {
+			// combining mark, e.g. the acute accent split off of "é"
+			continue
+		}
+		folded = append(folded, r)
+	}
+	return string(folded)
+}
+
+// StopwordFilter drops any token whose term is present in Set. Term
+// lookups are case-sensitive, so this filter should generally run after a
+// LowercaseFilter.
+type StopwordFilter struct {
+	Set map[string]struct{}
+}
+
+func (f StopwordFilter) Filter(in []Token) []Token {
+	out := make([]Token, 0, len(in))
+	for _, tok := range in {
+		if _, isStopword := f.Set[string(tok.Term)]; isStopword {
+			continue
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+// LengthFilter drops tokens shorter than Min or (if set) longer than Max
+// bytes.
+type LengthFilter struct {
+	Min int
+	Max int // 0 means unbounded
+}
+
+func (f LengthFilter) Filter(in []Token) []Token {
+	out := make([]Token, 0, len(in))
+	for _, tok := range in {
+		l := len(tok.Term)
+		if l < f.Min {
+			continue
+		}
+		if f.Max > 0 && l > f.Max {
+			continue
+		}
+		out = append(out, tok)
+	}
+	return out
+}
+
+// SnowballStemmerFilter reduces each token to its word stem using the
+// Snowball algorithm (e.g. "running" -> "run"), so that different
+// inflections of a word are indexed under the same term. Language must be
+// one of the languages supported by github.com/kljensen/snowball (e.g.
+// "english", "russian"). A token that fails to stem is left unchanged
+// rather than dropped.
+type SnowballStemmerFilter struct {
+	Language string
+}
+
+func (f SnowballStemmerFilter) Filter(in []Token) []Token {
+	out := make([]Token, len(in))
+	for i, tok := range in {
+		stemmed, err := snowball.Stem(string(tok.Term), f.Language, true)
+		if err != nil {
+			out[i] = tok
+			continue
+		}
+		out[i] = Token{Term: []byte(stemmed)}
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package inverted
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Token is one unit of text produced by a Tokenizer, before any
+// TokenFilters have run.
+type Token struct {
+	Term []byte
+}
+
+// Tokenizer splits raw text into a sequence of Tokens. Tokenizers do no
+// normalization themselves (lowercasing, stemming, dropping stop words,
+// ...) -- that is the job of TokenFilter, so a single tokenizer can be
+// combined with different filter chains for different languages.
+type Tokenizer interface {
+	Tokenize(in string) []Token
+}
+
+// UnicodeWordTokenizer splits on anything that is not a letter or a digit.
+// This is the tokenizer Analyzer.Text has always used.
+type UnicodeWordTokenizer struct{}
+
+func (UnicodeWordTokenizer) Tokenize(in string) []Token {
+	parts := strings.FieldsFunc(in, func(c rune) bool {
+		return !unicode.IsLetter(c) && !unicode.IsNumber(c)
+	})
+	return toTokens(parts)
+}
+
+// WhitespaceTokenizer splits only on whitespace and leaves everything else
+// -- including punctuation and casing -- untouched. This is the tokenizer
+// Analyzer.String has always used.
+type WhitespaceTokenizer struct{}
+
+func (WhitespaceTokenizer) Tokenize(in string) []Token {
+	parts := strings.FieldsFunc(in, unicode.IsSpace)
+	return toTokens(parts)
+}
+
+// KeywordTokenizer performs no splitting at all: the entire input becomes
+// a single token. Useful for identifiers, SKUs, or any other value that
+// must match exactly rather than be searched word-by-word.
+type KeywordTokenizer struct{}
+
+func (KeywordTokenizer) Tokenize(in string) []Token {
+	if in == "" {
+		return nil
+	}
+	return []Token{{Term: []byte(in)}}
+}
+
+func toTokens(parts []string) []Token {
+	out := make([]Token, len(parts))
+	for i, p := range parts {
+		out[i] = Token{Term: []byte(p)}
+	}
+	return out
+}
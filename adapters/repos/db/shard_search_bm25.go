@@ -0,0 +1,92 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package db
+
+import (
+	"sort"
+
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted"
+)
+
+// scoredDoc pairs a candidate docID with its relevance score, the unit
+// BM25Search sorts and paginates over.
+type scoredDoc struct {
+	docID uint32
+	score float64
+}
+
+// BM25Search returns the docIDs matching any of terms within propName,
+// ranked by scorer (pass nil to use the shard's default BM25Scorer) and
+// paginated the same way limit/offset works for any other query. Ranking
+// requires propName to have been indexed with HasFrequency set, since a
+// Scorer needs the per-document term counts and lengths only
+// frequency-tracked properties carry.
+func (s *Shard) BM25Search(propName string, terms []string, scorer inverted.Scorer,
+	limit, offset int) ([]uint32, error) {
+	if scorer == nil {
+		scorer = inverted.NewBM25Scorer(0, 0)
+	}
+
+	matches := map[uint32][]inverted.TermMatch{}
+	for _, term := range terms {
+		for docID, freq := range s.segments.SearchWithFrequencies(propName, []byte(term)) {
+			length, _ := s.segments.DocLength(propName, docID)
+			matches[docID] = append(matches[docID], inverted.TermMatch{
+				Term:      term,
+				TermCount: uint32(freq),
+				DocLength: length,
+			})
+		}
+	}
+
+	scored := make([]scoredDoc, 0, len(matches))
+	for docID, docMatches := range matches {
+		scored = append(scored, scoredDoc{
+			docID: docID,
+			score: scorer.Score(propName, docMatches, s.stats),
+		})
+	}
+
+	sort.Slice(scored, func(i, j int) bool {
+		if scored[i].score != scored[j].score {
+			return scored[i].score > scored[j].score
+		}
+		return scored[i].docID < scored[j].docID
+	})
+
+	scored = paginate(scored, limit, offset)
+
+	out := make([]uint32, len(scored))
+	for i, sd := range scored {
+		out[i] = sd.docID
+	}
+	return out, nil
+}
+
+// paginate slices scored the way a limit/offset query parameter pair
+// would: offset skips that many leading results, and a non-positive
+// limit means "no limit", matching how the query layer already treats
+// those defaults elsewhere.
+func paginate(scored []scoredDoc, limit, offset int) []scoredDoc {
+	if offset < 0 {
+		offset = 0
+	}
+	if offset >= len(scored) {
+		return nil
+	}
+	scored = scored[offset:]
+
+	if limit <= 0 || limit >= len(scored) {
+		return scored
+	}
+	return scored[:limit]
+}
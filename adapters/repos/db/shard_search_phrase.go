@@ -0,0 +1,103 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package db
+
+import (
+	"github.com/RoaringBitmap/roaring"
+)
+
+// PhraseSearch returns the docIDs where terms occur, in order, as a
+// phrase within propName -- i.e. consecutive occurrences allowing up to
+// slop other tokens in between. It requires propName to have been indexed
+// with HasFrequency set, since only those properties carry the token
+// positions phrase matching needs (see inverted.Countable.Positions).
+func (s *Shard) PhraseSearch(propName string, terms []string, slop int) (*roaring.Bitmap, error) {
+	if len(terms) == 0 {
+		return roaring.NewBitmap(), nil
+	}
+
+	positionsPerTerm := make([]map[uint32][]uint32, len(terms))
+	var candidates *roaring.Bitmap
+	for i, term := range terms {
+		positions := s.segments.SearchWithPositions(propName, []byte(term))
+		positionsPerTerm[i] = positions
+
+		docs := roaring.NewBitmap()
+		for docID := range positions {
+			docs.Add(docID)
+		}
+
+		if candidates == nil {
+			candidates = docs
+		} else {
+			candidates = roaring.And(candidates, docs)
+		}
+	}
+
+	if candidates.IsEmpty() {
+		return candidates, nil
+	}
+
+	result := roaring.NewBitmap()
+	it := candidates.Iterator()
+	for it.HasNext() {
+		docID := it.Next()
+
+		perTermPositions := make([][]uint32, len(terms))
+		for i := range terms {
+			perTermPositions[i] = positionsPerTerm[i][docID]
+		}
+
+		if phraseMatches(perTermPositions, slop) {
+			result.Add(docID)
+		}
+	}
+
+	return result, nil
+}
+
+// phraseMatches checks whether there is a chain of positions -- one per
+// entry in positionsPerTerm, in order -- where each position is strictly
+// after the previous one with at most slop other tokens in between. At
+// each step it keeps every occurrence of the next term that is within slop
+// of any surviving candidate, so every possible chain is covered.
+func phraseMatches(positionsPerTerm [][]uint32, slop int) bool {
+	if len(positionsPerTerm) == 0 {
+		return false
+	}
+
+	candidates := positionsPerTerm[0]
+	for i := 1; i < len(positionsPerTerm); i++ {
+		next := positionsPerTerm[i]
+
+		var advanced []uint32
+		for _, c := range candidates {
+			for _, p := range next {
+				if p <= c {
+					continue
+				}
+
+				gap := int(p) - int(c) - 1
+				if gap <= slop {
+					advanced = append(advanced, p)
+				}
+			}
+		}
+
+		if len(advanced) == 0 {
+			return false
+		}
+		candidates = advanced
+	}
+
+	return len(candidates) > 0
+}
@@ -0,0 +1,76 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPhraseMatches(t *testing.T) {
+	tests := []struct {
+		name      string
+		positions [][]uint32
+		slop      int
+		want      bool
+	}{
+		{
+			name:      "exact adjacent phrase matches at slop 0",
+			positions: [][]uint32{{0}, {1}, {2}},
+			slop:      0,
+			want:      true,
+		},
+		{
+			name:      "non-adjacent phrase does not match at slop 0",
+			positions: [][]uint32{{0}, {2}},
+			slop:      0,
+			want:      false,
+		},
+		{
+			name:      "one gap token matches within slop 1",
+			positions: [][]uint32{{0}, {2}},
+			slop:      1,
+			want:      true,
+		},
+		{
+			name:      "gap larger than slop does not match",
+			positions: [][]uint32{{0}, {3}},
+			slop:      1,
+			want:      false,
+		},
+		{
+			name:      "out-of-order occurrence is ignored in favor of a later one",
+			positions: [][]uint32{{5}, {0, 6}},
+			slop:      0,
+			want:      true,
+		},
+		{
+			name:      "empty term list never matches",
+			positions: nil,
+			slop:      0,
+			want:      false,
+		},
+		{
+			name:      "missing occurrence for one term means no match",
+			positions: [][]uint32{{0}, {}},
+			slop:      5,
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, phraseMatches(tt.positions, tt.slop))
+		})
+	}
+}
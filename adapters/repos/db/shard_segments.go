@@ -0,0 +1,64 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted/segment"
+)
+
+// segmentMergeInterval is how often a shard checks whether any of its
+// inverted-index segments are due for a size-tiered merge. This is
+// deliberately coarse -- Merge itself is cheap to call when there is
+// nothing to do, so there is no need to tune this per-workload.
+const segmentMergeInterval = 1 * time.Minute
+
+// startSegmentMerger launches the background goroutine that periodically
+// asks the shard's segment.Store to fold small segments together. It
+// returns a cancel func the shard should call on shutdown to stop the
+// goroutine.
+func (s *Shard) startSegmentMerger() context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(segmentMergeInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.segments.Merge(ctx); err != nil {
+					s.index.logger.WithField("action", "segment_merge").
+						WithError(err).Error("failed to merge inverted index segments")
+				}
+			}
+		}
+	}()
+
+	return cancel
+}
+
+// newSegmentStore creates the segment store for a shard's inverted index,
+// rooted at the shard's own directory, and loads whatever segments a
+// previous run already flushed there.
+func newSegmentStore(shardDir string) (*segment.Store, error) {
+	store := segment.NewStore(shardDir)
+	if err := store.Open(); err != nil {
+		return nil, errors.Wrap(err, "open segment store")
+	}
+	return store, nil
+}
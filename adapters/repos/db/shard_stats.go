@@ -0,0 +1,25 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package db
+
+import (
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted/stats"
+)
+
+// newStatsAggregate creates the collection-level term statistics
+// aggregate a shard's BM25Search (and any other Scorer-driven query)
+// reads from. It starts empty; extendInvertedIndices/PutObjectsBatch keep
+// it current as documents are indexed, and migrateBoltPostingsToSegments
+// rebuilds it wholesale for rows that predate this bookkeeping.
+func newStatsAggregate() *stats.Aggregate {
+	return stats.NewAggregate()
+}
@@ -0,0 +1,46 @@
+//                           _       _
+// __      _____  __ ___   ___  __ _| |_ ___
+// \ \ /\ / / _ \/ _` \ \ / / |/ _` | __/ _ \
+//  \ V  V /  __/ (_| |\ V /| | (_| | ||  __/
+//   \_/\_/ \___|\__,_| \_/ |_|\__,_|\__\___|
+//
+//  Copyright © 2016 - 2020 SeMI Technologies B.V. All rights reserved.
+//
+//  CONTACT: hello@semi.technology
+//
+
+package db
+
+import (
+	"github.com/pkg/errors"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/storobj"
+)
+
+// PutObjectsBatch is the entry point the bulk-import path (the batch
+// objects usecase, calling down through Shard) uses instead of looping
+// over the single-object write path: it analyzes the whole batch, then
+// indexes it with a single call into the segment store. For a batch of,
+// say, 1000 objects sharing 50 unique terms, this produces one segment
+// holding 50 posting lists, instead of 1000 tiny segments the background
+// merger would otherwise have to fold back together.
+func (s *Shard) PutObjectsBatch(objects []*storobj.Object, docIDs []uint32) error {
+	if len(objects) != len(docIDs) {
+		return errors.Errorf("got %d objects but %d docIDs", len(objects), len(docIDs))
+	}
+
+	builder := inverted.NewBatchBuilder()
+	for i, obj := range objects {
+		props, err := s.analyzeObject(obj)
+		if err != nil {
+			return errors.Wrapf(err, "analyze object %d of batch", i)
+		}
+		builder.Add(docIDs[i], props)
+	}
+
+	if builder.Len() == 0 {
+		return nil
+	}
+
+	return s.indexBatch(builder.Flush())
+}
@@ -12,16 +12,14 @@
 package db
 
 import (
-	"bytes"
-	"encoding/binary"
 	"fmt"
-	"hash/crc32"
-	"io"
 
 	"github.com/boltdb/bolt"
 	"github.com/pkg/errors"
 	"github.com/semi-technologies/weaviate/adapters/repos/db/helpers"
 	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted/postings"
+	"github.com/semi-technologies/weaviate/adapters/repos/db/inverted/segment"
 	"github.com/semi-technologies/weaviate/adapters/repos/db/storobj"
 	"github.com/semi-technologies/weaviate/entities/models"
 	"github.com/semi-technologies/weaviate/entities/schema"
@@ -50,294 +48,194 @@ func (s *Shard) analyzeObject(object *storobj.Object) ([]inverted.Property, erro
 		return nil, fmt.Errorf("expected schema to be map, but got %T", object.Schema())
 	}
 
-	return inverted.NewAnalyzer().Object(schemaMap, c.Properties)
+	props, err := inverted.NewAnalyzer().Object(schemaMap, c.Properties)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.applyPropertyAnalyzers(props, schemaMap, object.Class().String()), nil
 }
 
-func (s *Shard) extendInvertedIndices(tx *bolt.Tx, props []inverted.Property,
-	docID uint32) error {
-	for _, prop := range props {
-		b := tx.Bucket(helpers.BucketFromPropName(prop.Name))
-		if b == nil {
-			return fmt.Errorf("no bucket for prop '%s' found", prop.Name)
+// applyPropertyAnalyzers re-runs each property with a named analyzer
+// registered for this class (see inverted.SetPropertyAnalyzer, e.g.
+// "english", "russian", "keyword") back through
+// inverted.Analyzer.TextWithAnalyzer, since Object itself only ever applies
+// the default text/string pipeline. Properties with no override registered
+// are left untouched. An override is validated against the analyzer
+// registry up front by SetPropertyAnalyzer, so TextWithAnalyzer failing here
+// means the registry changed underneath it after the fact; rather than
+// failing the whole object write over one property, that property is logged
+// and left with its default-pipeline analysis.
+func (s *Shard) applyPropertyAnalyzers(props []inverted.Property, schemaMap map[string]interface{},
+	className string) []inverted.Property {
+	analyzer := inverted.NewAnalyzer()
+	for i, prop := range props {
+		name, ok := inverted.PropertyAnalyzer(className, prop.Name)
+		if !ok {
+			continue
 		}
 
-		if prop.HasFrequency {
-			for _, item := range prop.Items {
-				if err := s.extendInvertedIndexItemWithFrequency(b, item,
-					docID, item.TermFrequency); err != nil {
-					return errors.Wrapf(err, "extend index with item '%s'",
-						string(item.Data))
-				}
-			}
-		} else {
-			if len(prop.Items) != 1 {
-				return fmt.Errorf("prop %s has no frequency but %d items",
-					prop.Name, len(prop.Items))
-			}
-
-			if err := s.extendInvertedIndexItem(b, prop.Items[0], docID); err != nil {
-				return errors.Wrapf(err, "extend index with item '%s'",
-					string(prop.Items[0].Data))
-			}
-
+		value, ok := schemaMap[prop.Name].(string)
+		if !ok {
+			continue
 		}
 
+		items, err := analyzer.TextWithAnalyzer(name, value)
+		if err != nil {
+			s.index.logger.WithField("action", "analyze_property").
+				WithField("class", className).WithField("property", prop.Name).
+				WithField("analyzer", name).WithError(err).
+				Warn("falling back to default analyzer for property")
+			continue
+		}
+		props[i].Items = items
 	}
 
-	return nil
+	return props
 }
 
-func (s *Shard) deleteFromInvertedIndices(tx *bolt.Tx, props []inverted.Property,
+// extendInvertedIndices used to rewrite one bolt row per term on every
+// call; that bolt bucket is now gone. bolt still owns object storage
+// (hence tx is still passed in and used by the surrounding transaction for
+// that), but term postings go through indexBatch, the same "one segment
+// per batch" path PutObjectsBatch uses for bulk imports -- a batch of one
+// object is still a batch.
+func (s *Shard) extendInvertedIndices(tx *bolt.Tx, props []inverted.Property,
 	docID uint32) error {
-	for _, prop := range props {
-		b := tx.Bucket(helpers.BucketFromPropName(prop.Name))
-		if b == nil {
-			return fmt.Errorf("no bucket for prop '%s' found", prop.Name)
-		}
+	return s.indexBatch([][]inverted.Property{props}, []uint32{docID})
+}
 
-		for _, item := range prop.Items {
-			err := s.deleteFromInvertedIndicesProp(b, item, docID, prop.HasFrequency)
-			if err != nil {
-				return errors.Wrapf(err, "clean up prop %q", prop.Name)
-			}
-		}
+// indexBatch appends one new segment holding every props/docID pair and
+// folds each document's frequency-tracked properties into the shard's
+// stats.Aggregate, so a Scorer's next query sees them immediately rather
+// than waiting for the next migration to recompute them from scratch
+// (merges do not touch the aggregate). batchProps and docIDs are index-aligned, the form
+// inverted.BatchBuilder.Flush hands back.
+func (s *Shard) indexBatch(batchProps [][]inverted.Property, docIDs []uint32) error {
+	if _, err := s.segments.NewSegment(batchProps, docIDs); err != nil {
+		return errors.Wrap(err, "flush segment")
 	}
 
+	for _, props := range batchProps {
+		s.updateStats(props)
+	}
 	return nil
 }
 
-// TODO: needs to be called once per item, not per prop
-func (s *Shard) deleteFromInvertedIndicesProp(b *bolt.Bucket,
-	item inverted.Countable, docID uint32, hasFrequency bool) error {
-	data := b.Get(item.Data)
-	if len(data) == 0 {
-		// we want to delete from an empty row. Nothing to do
-		return nil
-	}
-
-	// remove the old checksum and doc count (0-4 = checksum, 5-8=docCount)
-	data = data[8:]
-	r := bytes.NewReader(data)
-
-	newDocCount := 0
-	newRow := bytes.NewBuffer(nil)
-	for {
-		nextDocIDBytes := make([]byte, 4)
-		_, err := r.Read(nextDocIDBytes)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-
-			return errors.Wrap(err, "read doc id")
-		}
-
-		var nextDocID uint32
-		if err := binary.Read(bytes.NewReader(nextDocIDBytes), binary.LittleEndian,
-			&nextDocID); err != nil {
-			return errors.Wrap(err, "read doc id from binary")
-		}
-
-		frequencyBytes := make([]byte, 4)
-		if hasFrequency {
-			// always read frequency if the property has one, so the reader offset is
-			// correct for the next round., i.e.only skip the loop after reading all
-			// contents
-			if n, err := r.Read(frequencyBytes); err != nil {
-				return errors.Wrapf(err, "read frequency (%d bytes)", n)
-			}
-		}
-
-		newDocCount++
-		if nextDocID == docID {
-			// we have found the one we want to delete, i.e. not copy into the
-			// updated list
+// updateStats folds one newly indexed document's frequency-tracked
+// properties into the shard's stats.Aggregate.
+func (s *Shard) updateStats(props []inverted.Property) {
+	for _, prop := range props {
+		if !prop.HasFrequency {
 			continue
 		}
 
-		if _, err := newRow.Write(nextDocIDBytes); err != nil {
-			return errors.Wrap(err, "write doc")
+		terms := make([]string, len(prop.Items))
+		for i, item := range prop.Items {
+			terms[i] = string(item.Data)
 		}
-
-		if hasFrequency {
-			if _, err := newRow.Write(frequencyBytes); err != nil {
-				return errors.Wrap(err, "write frequency")
-
-			}
-
-		}
-
-	}
-
-	countBytes := bytes.NewBuffer(make([]byte, 4))
-	binary.Write(countBytes, binary.LittleEndian, &newDocCount)
-
-	// combine back together
-	combined := append(countBytes.Bytes(), newRow.Bytes()...)
-
-	// finally calculate the checksum and prepend one more time.
-	chksum, err := s.checksum(combined)
-	if err != nil {
-		return err
-	}
-
-	combined = append(chksum, combined...)
-	if len(combined) != 0 && len(combined) > 0 {
-		// -8 to remove the checksum and doc count
-		// module 4 for 4 bytes of docID if no frequency
-		// module 8 for 8 bytes of docID if frequency
-		if hasFrequency && (len(combined)-8)%8 != 0 {
-			return fmt.Errorf("sanity check: invert row has invalid updated length %d"+
-				"with original length %d", len(combined), len(data))
-		}
-		if !hasFrequency && (len(combined)-8)%4 != 0 {
-			return fmt.Errorf("sanity check: invert row has invalid updated length %d"+
-				"with original length %d", len(combined), len(data))
-		}
-	}
-
-	err = b.Put(item.Data, combined)
-	if err != nil {
-		return err
+		s.stats.AddDocument(prop.Name, uint32(prop.Length()), terms)
 	}
+}
 
+// deleteFromInvertedIndices tombstones docID in the segment store. The
+// docIDs are not removed from their originating segment's term
+// dictionaries until that segment is next folded into a merge, see
+// inverted/segment.Store.Merge, but stats.Aggregate is decremented right
+// away (see updateStatsOnDelete) -- otherwise an update (a delete followed
+// by a re-add, see extendInvertedIndices) would inflate docCount and
+// docFreq a little further on every cycle, and a merge would not correct
+// them, since Merge does not touch the aggregate.
+func (s *Shard) deleteFromInvertedIndices(tx *bolt.Tx, props []inverted.Property,
+	docID uint32) error {
+	s.segments.Delete(docID)
+	s.updateStatsOnDelete(props)
 	return nil
 }
 
-// extendInvertedIndexItemWithFrequency maintains an inverted index row for one
-// search term,
-// the structure is as follows:
-//
-// Bytes | Meaning
-// 0..4   | count of matching documents as uint32 (little endian)
-// 5..7   | doc id of first matching doc as uint32 (little endian)
-// 8..11   | term frequency in first doc as float32 (little endian)
-// ...
-// (n-7)..(n-4) | doc id of last doc
-// (n-3)..n     | term frequency of last
-func (s *Shard) extendInvertedIndexItemWithFrequency(b *bolt.Bucket,
-	item inverted.Countable, docID uint32, freq float32) error {
-	data := b.Get(item.Data)
-
-	updated := bytes.NewBuffer(data)
-	if len(data) == 0 {
-		// this is the first time someones writing this row, initalize counter in
-		// beginning as zero
-		docCount := uint32(0)
-		binary.Write(updated, binary.LittleEndian, &docCount)
-	} else {
-		// remove the old checksum
-		data = data[4:]
-		updated = bytes.NewBuffer(data)
-	}
-
-	// append current document
-	if err := binary.Write(updated, binary.LittleEndian, &docID); err != nil {
-		return errors.Wrap(err, "write doc id")
-	}
-	if err := binary.Write(updated, binary.LittleEndian, &freq); err != nil {
-		return errors.Wrap(err, "write doc frequency")
-	}
-	extended := updated.Bytes()
-
-	// read and increase doc count
-	reader := bytes.NewReader(extended)
-	var docCount uint32
-	binary.Read(reader, binary.LittleEndian, &docCount)
-	docCount++
-	countBytes := bytes.NewBuffer(make([]byte, 0, 4))
-	binary.Write(countBytes, binary.LittleEndian, &docCount)
-
-	// combine back together
-	combined := append(countBytes.Bytes(), extended[4:]...)
-
-	// finally calculate the checksum and prepend one more time.
-	chksum, err := s.checksum(combined)
-	if err != nil {
-		return err
-	}
-
-	combined = append(chksum, combined...)
-	if len(combined) != 0 && len(combined) > 8 && (len(combined)-8)%8 != 0 {
-		// -8 to remove the checksum and doc count
-		// module 8 for 4 bytes of docID + frequency
-		return fmt.Errorf("sanity check: invert row has invalid updated length %d"+
-			"with original length %d", len(combined), len(data))
-	}
+// updateStatsOnDelete undoes updateStats's earlier bookkeeping for docID's
+// frequency-tracked properties, the same way deleting a posting undoes
+// indexing it.
+func (s *Shard) updateStatsOnDelete(props []inverted.Property) {
+	for _, prop := range props {
+		if !prop.HasFrequency {
+			continue
+		}
 
-	err = b.Put(item.Data, combined)
-	if err != nil {
-		return err
+		terms := make([]string, len(prop.Items))
+		for i, item := range prop.Items {
+			terms[i] = string(item.Data)
+		}
+		s.stats.RemoveDocument(prop.Name, uint32(prop.Length()), terms)
 	}
-
-	return nil
 }
 
-// TODO: merge this with the other one and just make it a flag, too much
-// duplication
-// extendInvertedIndexItem maintains an inverted index row for one search term,
-// the structure is as follows:
-//
-// Bytes | Meaning
-// 0..4   | count of matching documents as uint32 (little endian)
-// 5..7   | doc id of first matching doc as uint32 (little endian)
-// ...
-// (n-3)..n | doc id of last doc
-func (s *Shard) extendInvertedIndexItem(b *bolt.Bucket, item inverted.Countable,
-	docID uint32) error {
-	data := b.Get(item.Data)
-	updated := bytes.NewBuffer(data)
-	if len(data) == 0 {
-		// this is the first time someones writing this row, initalize counter in
-		// beginning as zero
-		docCount := uint32(0)
-		binary.Write(updated, binary.LittleEndian, &docCount)
-	} else {
-		// remove the old checksum
-		data = data[4:]
-		updated = bytes.NewBuffer(data)
-	}
-
-	// append current document
-	binary.Write(updated, binary.LittleEndian, &docID)
-	extended := updated.Bytes()
-
-	// read and increase doc count
-	reader := bytes.NewReader(extended)
-	var docCount uint32
-	binary.Read(reader, binary.LittleEndian, &docCount)
-	docCount++
-	countBytes := bytes.NewBuffer(make([]byte, 0, 4))
-	binary.Write(countBytes, binary.LittleEndian, &docCount)
+// migrateBoltPostingsToSegments folds every existing per-property bolt
+// bucket -- written by earlier versions of this code, in either the
+// pre-roaring flat-array format or the roaring-bitmap row format that
+// replaced it -- into a single bootstrap segment, then leaves the segment
+// store to run its normal append/merge lifecycle from there on. It is
+// meant to run once per shard, the first time a shard created before this
+// upgrade is opened; propNames/hasFrequency mirror the class's current
+// schema.Property definitions.
+func (s *Shard) migrateBoltPostingsToSegments(tx *bolt.Tx, propNames []string,
+	hasFrequency map[string]bool) error {
+	dict := make(map[string]*postings.List)
+
+	for _, propName := range propNames {
+		b := tx.Bucket(helpers.BucketFromPropName(propName))
+		if b == nil {
+			continue
+		}
 
-	// combine back together and save
-	combined := append(countBytes.Bytes(), extended[4:]...)
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var list *postings.List
+			var err error
+			if postings.IsLegacyRow(v) {
+				list, err = postings.UnmarshalLegacy(v, hasFrequency[propName])
+			} else {
+				list, err = postings.Unmarshal(v)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "migrate row for prop %q", propName)
+			}
 
-	// finally calculate the checksum and prepend one more time.
-	chksum, err := s.checksum(combined)
-	if err != nil {
-		return err
+			dict[segment.DictKey(propName, k)] = list
+		}
 	}
 
-	combined = append(chksum, combined...)
-	err = b.Put(item.Data, combined)
-	if err != nil {
-		return err
+	if len(dict) == 0 {
+		return nil
 	}
 
-	if len(combined) != 0 && len(combined) > 0 && (len(combined)-8)%4 != 0 {
-		// -8 to remove the checksum and doc count
-		// module 4 for 4 bytes of docID
-		return fmt.Errorf("sanity check: invert row has invalid updated length %d"+
-			"with original length %d", len(combined), len(data))
+	if _, err := s.segments.NewSegmentFromDict(dict); err != nil {
+		return errors.Wrap(err, "flush migrated segment")
+	}
+	for _, propName := range propNames {
+		if hasFrequency[propName] {
+			s.rebuildStats(propName)
+		}
 	}
-
 	return nil
 }
 
-func (s *Shard) checksum(in []byte) ([]byte, error) {
-	checksum := crc32.ChecksumIEEE(in)
-	buf := bytes.NewBuffer(make([]byte, 0, 4))
-	err := binary.Write(buf, binary.LittleEndian, &checksum)
-	return buf.Bytes(), err
+// rebuildStats recomputes propName's stats.Aggregate entry from the
+// segment store's current live postings, discarding whatever
+// AddDocument bookkeeping came before. Migrated rows predate per-document
+// length tracking (see Segment.docLengths), so their length is not
+// recoverable -- docCount is instead derived from postings cardinality
+// (AllDocIDs) so a migrated document still counts towards DocCount and
+// DocFrequency, and so BM25Scorer.Score doesn't treat the whole property
+// as empty; AverageLength stays best-effort from whatever lengths are
+// actually known. This is also safe to call any other time the aggregate
+// needs to be resynced with the postings it summarizes.
+func (s *Shard) rebuildStats(propName string) {
+	terms := s.segments.AllTerms(propName)
+	docLengths := s.segments.AllDocLengths(propName)
+	docCount := s.segments.AllDocIDs(propName).GetCardinality()
+
+	s.stats.Rebuild(propName, docCount, docLengths, terms, func(term string) uint64 {
+		return s.segments.Search(propName, []byte(term)).GetCardinality()
+	})
 }